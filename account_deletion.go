@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//deleted accounts are kept this long so the user can change their mind
+const defaultAccountDeletionGrace = 30 * 24 * time.Hour
+
+//schedule the caller's account for deletion. Their chirps disappear and every
+//credential is revoked right away; logging in again within the grace period
+//cancels the deletion.
+func (cfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	type parameters struct {
+		Password	string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+	//a stolen access token alone isn't enough to delete the account. Accounts
+	//created through an identity provider have no password to ask for.
+	if user.HashedPassword != "" {
+		if err := auth.CheckPasswordHash(params.Password, user.HashedPassword); err != nil {
+			respondWithError(w, 401, "Incorrect password")
+			return
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	deletedAt, err := qtx.SoftDeleteUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 409, "Account is already scheduled for deletion")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting user: %v", err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+	if err := revokeUserCredentials(r.Context(), qtx, userID); err != nil {
+		log.Printf("Error revoking credentials: %v", err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+
+	type response struct {
+		DeletedAt	time.Time `json:"deleted_at"`
+		PurgeAfter	time.Time `json:"purge_after"`
+	}
+
+	respondWithJSON(w, 202, response{
+		DeletedAt: deletedAt.Time,
+		PurgeAfter: deletedAt.Time.Add(cfg.accountDeletionGrace),
+	})
+}
+
+//a user logging in during the grace period keeps their account
+func (cfg *apiConfig) cancelAccountDeletion(ctx context.Context, user *database.User) error {
+	if !user.DeletedAt.Valid {
+		return nil
+	}
+	if err := cfg.dbQueries.CancelUserDeletion(ctx, user.ID); err != nil {
+		return err
+	}
+	log.Printf("Account deletion of user %v cancelled by login", user.ID)
+	user.DeletedAt = sql.NullTime{}
+	return nil
+}
+
+//hard delete accounts whose grace period is over, every interval until ctx is
+//done. Tokens and everything else the users own go with them through the
+//ON DELETE CASCADE foreign keys, see purgeUserTx for their chirps.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deletedBefore := time.Now().Add(-cfg.accountDeletionGrace)
+		userIDs, err := cfg.dbQueries.GetUsersToPurge(ctx, deletedBefore)
+		if err != nil {
+			log.Printf("Error getting deleted users: %v", err)
+		}
+		purged := 0
+		for _, userID := range userIDs {
+			ok, err := cfg.purgeUser(ctx, userID, deletedBefore)
+			if err != nil {
+				log.Printf("Error purging deleted user %v: %v", userID, err)
+				continue
+			}
+			if ok {
+				purged++
+			}
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted users", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeUser(ctx context.Context, userID uuid.UUID, deletedBefore time.Time) (bool, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	purged, err := purgeUserTx(ctx, cfg.dbQueries.WithTx(tx), userID, deletedBefore)
+	if err != nil || !purged {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+//the queries purging an account takes, *database.Queries does them
+type userPurger interface {
+	chirpDeleter
+	LockUserToPurge(ctx context.Context, arg database.LockUserToPurgeParams) (uuid.UUID, error)
+	GetUserChirpIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+}
+
+//hard delete an account, inside the caller's transaction. Its chirps are
+//deleted for good, the ones kept blank for their replies included. Replies by
+//other users stay in their threads, pointing at chirps that are gone, and the
+//deleted chirps of other users that are left without replies go too. Reports
+//false when the deletion was cancelled in the meantime.
+func purgeUserTx(ctx context.Context, q userPurger, userID uuid.UUID, deletedBefore time.Time) (bool, error) {
+	//a login cancelling the deletion waits for the purge, or the other way around
+	_, err := q.LockUserToPurge(ctx, database.LockUserToPurgeParams{
+		ID: userID,
+		DeletedBefore: deletedBefore,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	//deleting the chirps here rather than through ON DELETE CASCADE cleans up
+	//the blanked chirps they were the last replies to
+	chirpIDs, err := q.GetUserChirpIDs(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range chirpIDs {
+		chirp, err := q.GetChirpForUpdate(ctx, id)
+		//already gone with the last of its own replies
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := removeChirpTx(ctx, q, chirp); err != nil {
+			return false, err
+		}
+	}
+
+	if err := q.DeleteUser(ctx, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeleteUser(t *testing.T){
+	hashedPassword, err := auth.BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash err: %v", err)
+	}
+	deletedAt := time.Date(2025, 11, 6, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name			string
+		hashedPassword	string
+		body			string
+		alreadyDeleted	bool
+		want			int
+	}{
+		{name: "right password", hashedPassword: hashedPassword, body: `{"password":"correct horse"}`, want: 202},
+		{name: "wrong password", hashedPassword: hashedPassword, body: `{"password":"battery staple"}`, want: 401},
+		{name: "no password", hashedPassword: hashedPassword, body: `{}`, want: 401},
+		//accounts from an identity provider have no password to check
+		{name: "identity provider account", body: `{}`, want: 202},
+		{name: "already deleted", hashedPassword: hashedPassword, body: `{"password":"correct horse"}`, alreadyDeleted: true, want: 409},
+	}
+
+	for _, tc := range tests {
+		user := testUser("walt@example.com")
+		user.HashedPassword = tc.hashedPassword
+		db := newFakeDB(t)
+		db.on("GetUserByID", func(args []driver.Value) fakeResult {
+			return rowsOf(user)
+		})
+		db.on("SoftDeleteUser", func(args []driver.Value) fakeResult {
+			if tc.alreadyDeleted {
+				return rowsOf()
+			}
+			return rowsOf(sql.NullTime{Time: deletedAt, Valid: true})
+		})
+		for _, name := range []string{"IncrementTokenVersion", "RevokeAllUserPersonalAccessTokens", "RevokeAllUserRefreshTokens"} {
+			db.on(name, func(args []driver.Value) fakeResult {
+				return affected(1)
+			})
+		}
+		cfg := db.apiConfig()
+		cfg.accountDeletionGrace = defaultAccountDeletionGrace
+
+		w := httptest.NewRecorder()
+		cfg.handlerDeleteUser(w, authedRequest("DELETE", "/api/users", strings.NewReader(tc.body), user.ID))
+		if w.Code != tc.want {
+			t.Errorf("handlerDeleteUser %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+		if tc.want != 202 {
+			if len(db.called("COMMIT")) != 0 {
+				t.Errorf("handlerDeleteUser %s: want nothing committed", tc.name)
+			}
+			continue
+		}
+
+		var resp struct {
+			DeletedAt	time.Time `json:"deleted_at"`
+			PurgeAfter	time.Time `json:"purge_after"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding response err: %v", err)
+		}
+		if !resp.DeletedAt.Equal(deletedAt) || !resp.PurgeAfter.Equal(deletedAt.Add(defaultAccountDeletionGrace)) {
+			t.Errorf("handlerDeleteUser %s: want purge after the grace period, got %+v", tc.name, resp)
+		}
+		//every credential stops working together with the soft delete
+		for _, name := range []string{"SoftDeleteUser", "IncrementTokenVersion", "RevokeAllUserPersonalAccessTokens", "RevokeAllUserRefreshTokens"} {
+			if calls := db.called(name); len(calls) != 1 || calls[0][0] != user.ID.String() {
+				t.Errorf("handlerDeleteUser %s: want %s for %v, got %v", tc.name, name, user.ID, calls)
+			}
+		}
+		if len(db.called("COMMIT")) != 1 {
+			t.Errorf("handlerDeleteUser %s: want the deletion committed", tc.name)
+		}
+	}
+}
+
+func TestCancelAccountDeletion(t *testing.T){
+	db := newFakeDB(t)
+	db.on("CancelUserDeletion", func(args []driver.Value) fakeResult {
+		return affected(1)
+	})
+	cfg := db.apiConfig()
+
+	//logging in to an account that isn't being deleted changes nothing
+	user := testUser("walt@example.com")
+	if err := cfg.cancelAccountDeletion(context.Background(), &user); err != nil {
+		t.Fatalf("cancelAccountDeletion err: %v", err)
+	}
+	if calls := db.called("CancelUserDeletion"); len(calls) != 0 {
+		t.Errorf("cancelAccountDeletion of an active account: want no query, got %v", calls)
+	}
+
+	user.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := cfg.cancelAccountDeletion(context.Background(), &user); err != nil {
+		t.Fatalf("cancelAccountDeletion err: %v", err)
+	}
+	if calls := db.called("CancelUserDeletion"); len(calls) != 1 || calls[0][0] != user.ID.String() || user.DeletedAt.Valid {
+		t.Errorf("cancelAccountDeletion: want the deletion of %v cancelled, got %v with deleted_at %v", user.ID, calls, user.DeletedAt)
+	}
+}
+
+//fakeChirpStore keeps chirps in memory and does what the reply_count trigger
+//and the ON DELETE CASCADE foreign keys do in Postgres
+type fakeChirpStore struct {
+	chirps		map[uuid.UUID]*database.GetChirpForUpdateRow
+	revisions	map[uuid.UUID]bool
+	//users whose grace period is over
+	deletedUsers	map[uuid.UUID]bool
+	now			time.Time
+}
+
+func newFakeChirpStore() *fakeChirpStore {
+	return &fakeChirpStore{
+		chirps: map[uuid.UUID]*database.GetChirpForUpdateRow{},
+		revisions: map[uuid.UUID]bool{},
+		deletedUsers: map[uuid.UUID]bool{},
+		now: time.Date(2025, 11, 6, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+//post a chirp, replying to parent unless it's nil
+func (s *fakeChirpStore) post(userID uuid.UUID, parent *database.GetChirpForUpdateRow) *database.GetChirpForUpdateRow {
+	s.now = s.now.Add(time.Minute)
+	c := &database.GetChirpForUpdateRow{
+		ID: uuid.New(),
+		CreatedAt: s.now,
+		UpdatedAt: s.now,
+		Body: "chirp",
+		UserID: userID,
+	}
+	if parent != nil {
+		c.ParentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		c.RootID = parent.RootID
+		if !c.RootID.Valid {
+			c.RootID = c.ParentID
+		}
+		parent.ReplyCount++
+	}
+	s.chirps[c.ID] = c
+	s.revisions[c.ID] = true
+	return c
+}
+
+func (s *fakeChirpStore) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (database.GetChirpForUpdateRow, error) {
+	c, ok := s.chirps[id]
+	if !ok {
+		return database.GetChirpForUpdateRow{}, sql.ErrNoRows
+	}
+	return *c, nil
+}
+
+func (s *fakeChirpStore) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	c := s.chirps[id]
+	c.Body = ""
+	c.DeletedAt = sql.NullTime{Time: s.now, Valid: true}
+	return nil
+}
+
+func (s *fakeChirpStore) DeleteChirpRevisions(ctx context.Context, chirpID uuid.UUID) error {
+	delete(s.revisions, chirpID)
+	return nil
+}
+
+//replies keep parent_id and root_id, they aren't foreign keys
+func (s *fakeChirpStore) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	c, ok := s.chirps[id]
+	if !ok {
+		return nil
+	}
+	delete(s.chirps, id)
+	delete(s.revisions, id)
+	if c.ParentID.Valid {
+		if parent, ok := s.chirps[c.ParentID.UUID]; ok {
+			parent.ReplyCount--
+		}
+	}
+	return nil
+}
+
+func (s *fakeChirpStore) LockUserToPurge(ctx context.Context, arg database.LockUserToPurgeParams) (uuid.UUID, error) {
+	if !s.deletedUsers[arg.ID] {
+		return uuid.Nil, sql.ErrNoRows
+	}
+	return arg.ID, nil
+}
+
+func (s *fakeChirpStore) GetUserChirpIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	var chirps []*database.GetChirpForUpdateRow
+	for _, c := range s.chirps {
+		if c.UserID == userID {
+			chirps = append(chirps, c)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b *database.GetChirpForUpdateRow) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	ids := make([]uuid.UUID, len(chirps))
+	for i, c := range chirps {
+		ids[i] = c.ID
+	}
+	return ids, nil
+}
+
+func (s *fakeChirpStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	delete(s.deletedUsers, id)
+	for _, c := range s.chirps {
+		if c.UserID == id {
+			s.DeleteChirp(ctx, c.ID)
+		}
+	}
+	return nil
+}
+
+func TestDeleteChirpTx(t *testing.T){
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+	s := newFakeChirpStore()
+
+	root := s.post(alice, nil)
+	reply := s.post(bob, root)
+	nested := s.post(alice, reply)
+
+	//chirps with replies are blanked, without their revisions
+	for _, c := range []*database.GetChirpForUpdateRow{root, reply} {
+		if err := deleteChirpTx(ctx, s, c.ID); err != nil {
+			t.Fatalf("deleteChirpTx err: %v", err)
+		}
+		got, ok := s.chirps[c.ID]
+		if !ok || !got.DeletedAt.Valid || got.Body != "" || s.revisions[c.ID] {
+			t.Fatalf("deleteChirpTx with replies: want a tombstone, got %+v", got)
+		}
+	}
+
+	//deleting the last reply takes the blanked chirps above it along
+	if err := deleteChirpTx(ctx, s, nested.ID); err != nil {
+		t.Fatalf("deleteChirpTx err: %v", err)
+	}
+	if len(s.chirps) != 0 {
+		t.Errorf("deleteChirpTx of the last reply: want every chirp gone, %d left", len(s.chirps))
+	}
+}
+
+func TestDeleteChirpTxTombstoneWithReplies(t *testing.T){
+	ctx := context.Background()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	s := newFakeChirpStore()
+
+	root := s.post(alice, nil)
+	bobReply := s.post(bob, root)
+	carolReply := s.post(carol, root)
+	if err := deleteChirpTx(ctx, s, root.ID); err != nil {
+		t.Fatalf("deleteChirpTx err: %v", err)
+	}
+
+	//the tombstone stays while any reply is left
+	if err := deleteChirpTx(ctx, s, bobReply.ID); err != nil {
+		t.Fatalf("deleteChirpTx err: %v", err)
+	}
+	got, ok := s.chirps[root.ID]
+	if !ok || !got.DeletedAt.Valid || got.ReplyCount != 1 {
+		t.Fatalf("deleteChirpTx of one of two replies: want the tombstone with 1 reply, got %+v", got)
+	}
+	if _, ok := s.chirps[carolReply.ID]; !ok {
+		t.Fatalf("deleteChirpTx of one of two replies: want the other reply kept")
+	}
+
+	if err := deleteChirpTx(ctx, s, carolReply.ID); err != nil {
+		t.Fatalf("deleteChirpTx err: %v", err)
+	}
+	if len(s.chirps) != 0 {
+		t.Errorf("deleteChirpTx of the last reply: want the tombstone gone, %d chirps left", len(s.chirps))
+	}
+}
+
+func TestPurgeUserTx(t *testing.T){
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+	s := newFakeChirpStore()
+
+	//alice started a thread bob replied in
+	root := s.post(alice, nil)
+	bobReply := s.post(bob, root)
+	//alice also talked to herself and replied to bob
+	monologue := s.post(alice, nil)
+	s.post(alice, monologue)
+	bobChirp := s.post(bob, nil)
+	s.post(alice, bobChirp)
+	s.post(alice, nil)
+	//bob deleted a chirp of his that only alice replied to
+	bobDeleted := s.post(bob, nil)
+	s.post(alice, bobDeleted)
+	if err := deleteChirpTx(ctx, s, bobDeleted.ID); err != nil {
+		t.Fatalf("deleteChirpTx err: %v", err)
+	}
+
+	s.deletedUsers[alice] = true
+	purged, err := purgeUserTx(ctx, s, alice, s.now)
+	if err != nil {
+		t.Fatalf("purgeUserTx err: %v", err)
+	}
+	if !purged {
+		t.Fatalf("purgeUserTx: want purged")
+	}
+
+	//every chirp of alice is gone, and so is bob's deleted chirp she was the
+	//last to reply to
+	if len(s.chirps) != 2 {
+		t.Errorf("purgeUserTx: want bob's 2 chirps left, got %d", len(s.chirps))
+	}
+	if _, ok := s.chirps[bobDeleted.ID]; ok {
+		t.Errorf("purgeUserTx: want the tombstone left without replies gone")
+	}
+	if s.chirps[bobChirp.ID].ReplyCount != 0 {
+		t.Errorf("purgeUserTx: want alice's reply to bob uncounted, got reply_count %d", s.chirps[bobChirp.ID].ReplyCount)
+	}
+
+	//bob's reply is still a reply in alice's thread, so replying to it stays
+	//in that thread too
+	gotReply := s.chirps[bobReply.ID]
+	if gotReply.ParentID.UUID != root.ID || gotReply.RootID.UUID != root.ID {
+		t.Errorf("purgeUserTx: want bob's reply to keep its parent and root, got %+v", gotReply)
+	}
+	later := s.post(bob, gotReply)
+	if later.RootID.UUID != root.ID {
+		t.Errorf("reply after purgeUserTx: want root %v, got %v", root.ID, later.RootID)
+	}
+
+	//and deleting it doesn't trip over the missing parent
+	for _, id := range []uuid.UUID{later.ID, bobReply.ID} {
+		if err := deleteChirpTx(ctx, s, id); err != nil {
+			t.Fatalf("deleteChirpTx of a reply to a purged chirp err: %v", err)
+		}
+	}
+	if len(s.chirps) != 1 {
+		t.Errorf("deleteChirpTx after purge: want only bob's chirp left, got %d chirps", len(s.chirps))
+	}
+}
+
+func TestPurgeUserTxCancelled(t *testing.T){
+	ctx := context.Background()
+	alice := uuid.New()
+	s := newFakeChirpStore()
+	s.post(alice, nil)
+
+	//alice logged in again, so her account is no longer waiting to be purged
+	purged, err := purgeUserTx(ctx, s, alice, s.now)
+	if err != nil {
+		t.Fatalf("purgeUserTx err: %v", err)
+	}
+	if purged || len(s.chirps) != 1 {
+		t.Errorf("purgeUserTx of a cancelled deletion: want nothing deleted, got purged %v with %d chirps", purged, len(s.chirps))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//chirps can be edited for an hour after posting unless CHIRP_EDIT_WINDOW says
+//otherwise
+const defaultChirpEditWindow = time.Hour
+
+//an earlier body of an edited chirp
+type ChirpRevision struct {
+	Body		string `json:"body"`
+	//when this body was posted or last edited
+	CreatedAt	time.Time `json:"created_at"`
+	//when the next edit replaced it
+	ReplacedAt	time.Time `json:"replaced_at"`
+}
+
+//change the body of a chirp. Only the author can, and only within the edit
+//window; the old body is kept as a revision.
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Error parsing chirp ID from string to UUID: %v", err)
+		respondWithError(w, 400, "Error parsing chirp ID")
+		return
+	}
+
+	type parameters struct {
+		Body	string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding params.body: %v", err)
+		respondWithError(w, 400, "Error decoding json")
+		return
+	}
+
+	body, err := validateChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp input!")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error updating chirp")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	//lock the chirp so concurrent edits each get their own revision
+	chirp, err := qtx.GetChirpForUpdate(r.Context(), id)
+	//deleted chirps that are kept for their replies can't be edited
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirp.DeletedAt.Valid) {
+		respondWithError(w, 404, "Matching chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting chirp by id: %v", err)
+		respondWithError(w, 500, "Error updating chirp")
+		return
+	}
+
+	//unlike deleting, moderators can't put words in someone else's mouth
+	if chirp.UserID != userID {
+		log.Printf("User has no access to chirp!")
+		respondWithError(w, 403, "chirp access forbidden")
+		return
+	}
+	if time.Since(chirp.CreatedAt) > cfg.chirpEditWindow {
+		respondWithError(w, 403, "Chirps can only be edited for "+cfg.chirpEditWindow.String()+" after posting")
+		return
+	}
+
+	//nothing to keep a revision of
+	if body != chirp.Body {
+		err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			ChirpID: chirp.ID,
+			Body: chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		if err != nil {
+			log.Printf("Error storing chirp revision: %v", err)
+			respondWithError(w, 500, "Error updating chirp")
+			return
+		}
+		updated, err := qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID: chirp.ID,
+			Body: body,
+		})
+		if err != nil {
+			log.Printf("Error updating chirp: %v", err)
+			respondWithError(w, 500, "Error updating chirp")
+			return
+		}
+		chirp = database.GetChirpForUpdateRow(updated)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		respondWithError(w, 500, "Error updating chirp")
+		return
+	}
+
+	respondWithJSON(w, 200, Chirp{
+		ID: chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body: chirp.Body,
+		UserID: chirp.UserID,
+		Edited: chirp.EditCount > 0,
+		EditCount: chirp.EditCount,
+		InReplyTo: nullUUIDPtr(chirp.ParentID),
+		RootID: nullUUIDPtr(chirp.RootID),
+		ReplyCount: chirp.ReplyCount,
+	})
+}
+
+//list the earlier bodies of a chirp, newest first
+func (cfg *apiConfig) handlerGetChirpRevisions(w http.ResponseWriter, r *http.Request){
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Error parsing chirp ID from string to UUID: %v", err)
+		respondWithError(w, 400, "Error parsing chirp ID")
+		return
+	}
+
+	//revisions of hidden chirps are hidden too
+	if _, err := cfg.dbQueries.GetChirp(r.Context(), id); errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Matching chirp not found")
+		return
+	} else if err != nil {
+		log.Printf("Error getting chirp by id: %v", err)
+		respondWithError(w, 500, "Error getting revisions")
+		return
+	}
+
+	revisions, err := cfg.dbQueries.GetChirpRevisions(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting chirp revisions: %v", err)
+		respondWithError(w, 500, "Error getting revisions")
+		return
+	}
+
+	resp := make([]ChirpRevision, len(revisions))
+	for i, rev := range revisions {
+		resp[i] = ChirpRevision{
+			Body: rev.Body,
+			CreatedAt: rev.CreatedAt,
+			ReplacedAt: rev.ReplacedAt,
+		}
+	}
+
+	respondWithJSON(w, 200, resp)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+func TestUpdateChirp(t *testing.T){
+	author := uuid.New()
+	tests := []struct {
+		name		string
+		body		string
+		userID		uuid.UUID
+		age			time.Duration
+		deleted		bool
+		want		int
+		//the body stored, empty when nothing should change
+		wantBody	string
+	}{
+		{name: "edit", body: "a kerfuffle\x07 after all", userID: author, want: 200, wantBody: "a **** after all"},
+		{name: "same body", body: "first take", userID: author, want: 200},
+		{name: "empty", body: "\x07", userID: author, want: 400},
+		{name: "too long", body: strings.Repeat("a", maxChirpLength+1), userID: author, want: 400},
+		{name: "someone else's", body: "second take", userID: uuid.New(), want: 403},
+		{name: "edit window over", body: "second take", userID: author, age: 2 * defaultChirpEditWindow, want: 403},
+		{name: "deleted", body: "second take", userID: author, deleted: true, want: 404},
+	}
+
+	for _, tc := range tests {
+		posted := time.Now().Add(-tc.age - time.Minute)
+		chirp := database.GetChirpForUpdateRow{
+			ID: uuid.New(),
+			CreatedAt: posted,
+			UpdatedAt: posted,
+			Body: "first take",
+			UserID: author,
+		}
+		if tc.deleted {
+			chirp.Body = ""
+			chirp.DeletedAt = sql.NullTime{Time: posted, Valid: true}
+		}
+		db := newFakeDB(t)
+		db.on("GetChirpForUpdate", func(args []driver.Value) fakeResult {
+			return rowsOf(chirp)
+		})
+		db.on("CreateChirpRevision", func(args []driver.Value) fakeResult {
+			return affected(1)
+		})
+		db.on("UpdateChirpBody", func(args []driver.Value) fakeResult {
+			updated := chirp
+			updated.Body = args[1].(string)
+			updated.UpdatedAt = time.Now()
+			updated.EditCount++
+			return rowsOf(database.UpdateChirpBodyRow(updated))
+		})
+		cfg := db.apiConfig()
+		cfg.chirpEditWindow = defaultChirpEditWindow
+
+		body, _ := json.Marshal(map[string]string{"body": tc.body})
+		r := authedRequest("PUT", "/api/chirps/"+chirp.ID.String(), strings.NewReader(string(body)), tc.userID)
+		r.SetPathValue("chirpID", chirp.ID.String())
+		w := httptest.NewRecorder()
+		cfg.handlerUpdateChirp(w, r)
+		if w.Code != tc.want {
+			t.Errorf("handlerUpdateChirp %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+		//invalid bodies are turned away before the chirp is even looked up
+		if tc.want == 400 && len(db.calls) != 0 {
+			t.Errorf("handlerUpdateChirp %s: want no queries, got %v", tc.name, db.calls)
+		}
+
+		updates := db.called("UpdateChirpBody")
+		revisions := db.called("CreateChirpRevision")
+		if tc.wantBody == "" {
+			if len(updates) != 0 || len(revisions) != 0 {
+				t.Errorf("handlerUpdateChirp %s: want nothing changed, got %v %v", tc.name, updates, revisions)
+			}
+			continue
+		}
+		if len(updates) != 1 || updates[0][1] != tc.wantBody {
+			t.Errorf("handlerUpdateChirp %s: want body %q stored, got %v", tc.name, tc.wantBody, updates)
+		}
+		//the old body is kept
+		if len(revisions) != 1 || revisions[0][1] != chirp.Body {
+			t.Errorf("handlerUpdateChirp %s: want a revision of %q, got %v", tc.name, chirp.Body, revisions)
+		}
+		var resp Chirp
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding chirp err: %v", err)
+		}
+		if resp.Body != tc.wantBody || !resp.Edited || resp.EditCount != 1 {
+			t.Errorf("handlerUpdateChirp %s: want an edited chirp with body %q, got %+v", tc.name, tc.wantBody, resp)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//run a command line subcommand instead of the server, e.g.
+//  chirpy create-admin -email admin@example.com
+//  chirpy set-role -email mod@example.com -role moderator
+func (cfg *apiConfig) runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "create-admin":
+		return cfg.commandCreateAdmin(ctx, args[1:])
+	case "set-role":
+		return cfg.commandSetRole(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, expected create-admin or set-role", args[0])
+	}
+}
+
+//create the first admin, or promote an existing user. A new account's
+//password is read from ADMIN_PASSWORD or stdin so it doesn't end up in the
+//shell history.
+func (cfg *apiConfig) commandCreateAdmin(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin account")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	user, err := cfg.dbQueries.UserLogin(ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = cfg.createAdminUser(ctx, *email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.setRole(ctx, user, auth.RoleAdmin); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now an admin\n", user.Email)
+	return nil
+}
+
+func (cfg *apiConfig) createAdminUser(ctx context.Context, email string) (database.User, error) {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password for the new admin: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return database.User{}, fmt.Errorf("reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if err := cfg.passwordPolicy.Validate(password, email); err != nil {
+		return database.User{}, err
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.dbQueries.CreateUser(ctx, database.CreateUserParams{
+		HashedPassword: hashedPassword,
+		Email: email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	//whoever runs the command controls the server, no need to verify the email
+	if err := cfg.dbQueries.MarkEmailVerified(ctx, user.ID); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+//change the role of an existing user
+func (cfg *apiConfig) commandSetRole(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user")
+	role := flags.String("role", "", "user, moderator or admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || !auth.ValidRole(*role) {
+		return errors.New("-email and -role (user, moderator or admin) are required")
+	}
+
+	user, err := cfg.dbQueries.UserLogin(ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", *email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.setRole(ctx, user, *role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now a %s\n", user.Email, *role)
+	return nil
+}
+
+//set a user's role. This also bumps the token version, so access tokens with
+//the old roles stop working and the user has to refresh.
+func (cfg *apiConfig) setRole(ctx context.Context, user database.User, role string) error {
+	return cfg.dbQueries.SetUserRole(ctx, database.SetUserRoleParams{
+		Role: role,
+		ID: user.ID,
+	})
+}
@@ -9,11 +9,13 @@ import (
 	"time"
 	"encoding/json"
 	"log"
-	"unicode/utf8"
 	"errors"
 	"database/sql"
 	"github.com/paul39-33/chirpy/internal/auth"
-	"sort"
+	"context"
+	"github.com/paul39-33/chirpy/internal/mailer"
+	"github.com/paul39-33/chirpy/internal/oidc"
+	"github.com/lib/pq"
 )
 
 //struct to keep track of number of requests
@@ -21,10 +23,34 @@ type apiConfig struct {
 	fileserverHits	atomic.Int32
 	dbQueries		*database.Queries
 	platform		string
-	secret			string
+	jwtKeys			*auth.KeyRing
 	polkaKey		string
+	db				*sql.DB
+	accountLockout	auth.LockoutPolicy
+	ipLockout		auth.LockoutPolicy
+	mailer			mailer.Mailer
+	//public URL of the API, used in emails
+	baseURL			string
+	//block unverified accounts from posting chirps
+	requireVerifiedEmail	bool
+	passwordPolicy	auth.PasswordPolicy
+	//hasher for new passwords, logins upgrade hashes made with older settings
+	passwordHasher	auth.PasswordHasher
+	//external OpenID Connect provider, nil when OIDC login is disabled
+	oidcProvider	*oidc.Provider
+	//how long deleted accounts can still be restored by logging in
+	accountDeletionGrace	time.Duration
+	//how long after posting authors can still edit a chirp
+	chirpEditWindow	time.Duration
+	//access tokens revoked by logging out
+	denylist		*auth.Denylist
+	//compared against when logging in to an unknown account
+	dummyPasswordHash	string
 }
 
+//refresh tokens are valid for 60 days from the moment they are issued
+const refreshTokenExp = (60 * 24) * time.Hour
+
 //struct for userlogin json data
 type UserLogin struct {
 	ID 				uuid.UUID `json:"id"`
@@ -32,6 +58,8 @@ type UserLogin struct {
 	UpdatedAt		time.Time `json:"updated_at"`
 	Email			string `json:"email"`
 	IsChirpyRed		bool `json:"is_chirpy_red"`
+	EmailVerified	bool `json:"email_verified"`
+	Role			string `json:"role"`
 	Token			string `json:"token"`
 	RefreshToken	string `json:"refresh_token"`
 }
@@ -42,6 +70,8 @@ type User struct {
 	UpdatedAt		time.Time `json:"updated_at"`
 	Email			string `json:"email"`
 	IsChirpyRed		bool `json:"is_chirpy_red"`
+	EmailVerified	bool `json:"email_verified"`
+	Role			string `json:"role"`
 }
 
 type Chirp struct {
@@ -50,6 +80,19 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	Edited    bool      `json:"edited"`
+	EditCount int32     `json:"edit_count"`
+	//the chirp this one replies to and the chirp that started the thread, null
+	//for chirps that aren't replies
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	RootID     *uuid.UUID `json:"root_id"`
+	ReplyCount int32      `json:"reply_count"`
+}
+
+//a page of chirps, next_cursor is null on the last page
+type ChirpPage struct {
+	Chirps		[]Chirp `json:"chirps"`
+	NextCursor	*string `json:"next_cursor"`
 }
 
 //increments fileserverHits every time its called
@@ -77,7 +120,7 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request){
 
 //handler to reset the fileserverHits count and delete all users
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request){
-	//check if the reset is being done by a dev
+	//even admins can only wipe the database of a dev server
 	if cfg.platform	!= "dev"{
 		respondWithError(w, 403, "Command must be done by a dev")
 		return
@@ -109,51 +152,102 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(params.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
 		respondWithError(w, 400, "Error hashing password")
 		return
 	}
 
-	user, err := cfg.dbQueries.CreateUser(r.Context(), database.CreateUserParams{
+	//the response is the same whether or not the email was already
+	//registered, the owner of the email finds out which it was from the email
+	//they get
+	if err := cfg.completeSignup(r.Context(), params.Email, hashedPassword); err != nil {
+		log.Printf("Error signing up: %v", err)
+		respondWithError(w, 500, "Error creating user")
+		return
+	}
+
+	type response struct {
+		Status	string `json:"status"`
+	}
+
+	respondWithJSON(w, 202, response{Status: "pending_verification"})
+}
+
+//create a new account and email a verification token, or tell the owner of
+//an existing account that someone tried to sign up with their email. Emails
+//that can't be sent are only logged, so both cases respond alike.
+func (cfg *apiConfig) completeSignup(ctx context.Context, email, hashedPassword string) error {
+	existing, err := cfg.dbQueries.UserLogin(ctx, email)
+	if err == nil {
+		if err := cfg.sendAlreadyRegisteredEmail(ctx, existing); err != nil {
+			log.Printf("Error sending already registered email: %v", err)
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	user, err := cfg.dbQueries.CreateUser(ctx, database.CreateUserParams{
 		HashedPassword: hashedPassword,
-		Email: params.Email,
+		Email: email,
 	})
+	//a concurrent signup with the same email got there first, its owner
+	//already gets the verification email
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error decoding request: %v", err)
-		respondWithError(w, 400, "Error creating user")
-		return
+		return err
 	}
 
-	createdUser := User {
-		ID: user.ID,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Email: user.Email,
+	//the account is usable right away, so a failed email is only logged and
+	//the user can ask for a new one
+	if err := cfg.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
 	}
+	return nil
+}
 
-	respondWithJSON(w, 201, createdUser)
+//check a new password against the password policy, responding with every
+//failed rule when it doesn't pass
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password, email string) bool {
+	err := cfg.passwordPolicy.Validate(password, email)
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		respondWithValidationError(w, "Password does not meet the password policy", policyErr.Violations)
+		return false
+	}
+	return true
 }
 
 func (cfg *apiConfig) handlerCreateChirps(w http.ResponseWriter, r *http.Request){
-	//get user token
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting user token: %v", err)
-		respondWithError(w, 400, "Error validating token")
-		return
-	}
-	//validate user token
-	userID, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		log.Printf("Error validating user token: %v", err)
-		respondWithError(w, 401, "Invalid token session")
-		return
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	if cfg.requireVerifiedEmail {
+		user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error getting user data: %v", err)
+			respondWithError(w, 500, "Error retrieving user data")
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			respondWithError(w, 403, "Email address not verified")
+			return
+		}
 	}
 
 	type parameters struct {
-		Body	string		`json:"body"`
+		Body		string		`json:"body"`
+		InReplyTo	*uuid.UUID	`json:"in_reply_to"`
 	}
 	params := parameters{}
 
@@ -164,20 +258,38 @@ func (cfg *apiConfig) handlerCreateChirps(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//count the length of the Body characters
-	runeCount := utf8.RuneCountInString(params.Body)
-	if runeCount == 0 || runeCount > 280 {
+	body, err := validateChirpBody(params.Body)
+	if err != nil {
 		log.Printf("Invalid body length!")
 		respondWithError(w, http.StatusBadRequest, "Invalid chirp input!")
 		return
 	}
 
-	//clean profanity texts
-	params.Body = cleanProfanity(params.Body)
+	//replies keep the thread's root so whole conversations can be found
+	var parentID, rootID uuid.NullUUID
+	if params.InReplyTo != nil {
+		parent, err := cfg.dbQueries.GetChirp(r.Context(), *params.InReplyTo)
+		if errors.Is(err, sql.ErrNoRows){
+			respondWithError(w, 404, "Chirp being replied to not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting chirp by id: %v", err)
+			respondWithError(w, 500, "Error creating chirp")
+			return
+		}
+		parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		rootID = parent.RootID
+		if !rootID.Valid {
+			rootID = parentID
+		}
+	}
 
 	chirp, err := cfg.dbQueries.CreateChirps(r.Context(), database.CreateChirpsParams{
-		Body: params.Body,
+		Body: body,
 		UserID: userID,
+		ParentID: parentID,
+		RootID: rootID,
 	})
 	if err != nil {
 		log.Printf("Error creating chirp: %v", err)
@@ -190,70 +302,102 @@ func (cfg *apiConfig) handlerCreateChirps(w http.ResponseWriter, r *http.Request
 		UpdatedAt: chirp.UpdatedAt,
 		Body: chirp.Body,
 		UserID: chirp.UserID,
+		Edited: chirp.EditCount > 0,
+		EditCount: chirp.EditCount,
+		InReplyTo: nullUUIDPtr(chirp.ParentID),
+		RootID: nullUUIDPtr(chirp.RootID),
+		ReplyCount: chirp.ReplyCount,
 	}
 
 	respondWithJSON(w, 201, createdChirp)
 }
 
-//get all chirps
+//get a page of chirps, optionally by one author, oldest first unless
+//?sort=desc. Every combination of filters and sort order is done in SQL.
 func(cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request){
-	//get optional sort parameter from user
-	sortInput := r.URL.Query().Get("sort")
-	//get optional authorID parameter from user
-	authorID := r.URL.Query().Get("author_id")
-	//if user inserted an author ID
-	if authorID != "" {
+	query := r.URL.Query()
+
+	descending, err := parseSortOrder(query.Get("sort"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	var author uuid.NullUUID
+	if authorID := query.Get("author_id"); authorID != "" {
 		id, err := uuid.Parse(authorID)
 		if err != nil {
 			log.Printf("Error parsing author ID from string to UUID: %v", err)
 			respondWithError(w, 400, "Error parsing author ID")
 			return
 		}
-		chirpsByAuthor, err := cfg.dbQueries.GetChirpsByAuthor(r.Context(), id)
-		if err != nil {
-			log.Printf("Error getting chirps: %v", err)
-			respondWithError(w, 400, "Error getting chirps")
-			return
-		}
-		
-		resp := make([]Chirp, len(chirpsByAuthor))
-		for i, c := range chirpsByAuthor {
-			resp[i] = Chirp{
-				ID:	c.ID,
-				CreatedAt:	c.CreatedAt,
-				UpdatedAt:	c.UpdatedAt,
-				Body:	c.Body,
-				UserID:	c.UserID,
-			}
+		author = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	cursorCreatedAt, cursorID, err := decodeChirpCursor(query.Get("cursor"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid cursor")
+		return
+	}
+
+	params := database.ListChirpsParams{
+		AuthorID: author,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID: cursorID,
+		//fetch one extra chirp to find out if there is a next page
+		PageSize: pageSize + 1,
+	}
+	var chirps []database.ListChirpsRow
+	if descending {
+		var rows []database.ListChirpsDescRow
+		rows, err = cfg.dbQueries.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(params))
+		for _, c := range rows {
+			chirps = append(chirps, database.ListChirpsRow(c))
 		}
-		respondWithJSON(w, 200, resp)
 	} else {
-		getChirps, err := cfg.dbQueries.GetChirps(r.Context())
-		if err != nil {
-			log.Printf("Error getting chirps: %v", err)
-			respondWithError(w, 400, "Error getting chirps")
-			return
-		}
-	
-		resp := make([]Chirp, len(getChirps))
-		for i, c := range getChirps {
-			resp[i] = Chirp{
-				ID:	c.ID,
-				CreatedAt:	c.CreatedAt,
-				UpdatedAt:	c.UpdatedAt,
-				Body:	c.Body,
-				UserID:	c.UserID,
-			}
-		}
-		//implement desc sorting if user specifies
-		if sortInput == "desc" {
-			sort.Slice(resp, func(i, j int) bool { return resp[i].CreatedAt.After(resp[j].CreatedAt) })
-		}
-	//if no specific input or if input is "asc" then return in asc order
-	respondWithJSON(w, 200, resp)
-		
+		chirps, err = cfg.dbQueries.ListChirps(r.Context(), params)
 	}
-	
+	if err != nil {
+		log.Printf("Error getting chirps: %v", err)
+		respondWithError(w, 500, "Error getting chirps")
+		return
+	}
+
+	resp := ChirpPage{Chirps: make([]Chirp, 0, len(chirps))}
+	if len(chirps) > int(pageSize) {
+		chirps = chirps[:pageSize]
+		last := chirps[len(chirps)-1]
+		nextCursor := encodeChirpCursor(last.CreatedAt, last.ID)
+		resp.NextCursor = &nextCursor
+		cfg.setNextPageLink(w, r, nextCursor)
+	}
+	for _, c := range chirps {
+		resp.Chirps = append(resp.Chirps, Chirp{
+			ID:	c.ID,
+			CreatedAt:	c.CreatedAt,
+			UpdatedAt:	c.UpdatedAt,
+			Body:	c.Body,
+			UserID:	c.UserID,
+			Edited:	c.EditCount > 0,
+			EditCount:	c.EditCount,
+			InReplyTo:	nullUUIDPtr(c.ParentID),
+			RootID:	nullUUIDPtr(c.RootID),
+			ReplyCount:	c.ReplyCount,
+		})
+	}
+
+	respondWithJSON(w, 200, resp)
+}
+
+//publish the public keys used to verify access tokens
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.jwtKeys.JWKS())
 }
 
 //get specific chirp by id
@@ -287,6 +431,11 @@ func(cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request){
 		UpdatedAt: chirp.UpdatedAt,
 		Body: chirp.Body,
 		UserID: chirp.UserID,
+		Edited: chirp.EditCount > 0,
+		EditCount: chirp.EditCount,
+		InReplyTo: nullUUIDPtr(chirp.ParentID),
+		RootID: nullUUIDPtr(chirp.RootID),
+		ReplyCount: chirp.ReplyCount,
 	}
 
 	respondWithJSON(w, 200, resp)
@@ -306,54 +455,138 @@ func(cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	user, err := cfg.dbQueries.UserLogin(r.Context(), params.Email)
+	//refuse to even check the password while the account or client is locked out
+	ip := clientIP(r)
+	wait, err := cfg.loginLockedFor(r.Context(), params.Email, ip)
 	if err != nil {
-		log.Printf("Error getting password from database: %v", err)
-		respondWithError(w, 400, "Error getting user")
+		log.Printf("Error checking login lockout: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if wait > 0 {
+		respondWithLockout(w, wait)
 		return
 	}
 
-	//compare pwFromDatabase with the password input
-	if err = auth.CheckPasswordHash(params.Password, user.HashedPassword); err != nil {
+	user, err := cfg.dbQueries.UserLogin(r.Context(), params.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting password from database: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	//unknown emails get the same answer, after the same amount of work, as a
+	//wrong password so logins can't be used to find out who is registered
+	if err := cfg.checkLoginPassword(params.Password, user, err == nil); err != nil {
 		log.Printf("Incorrect email or password")
+		cfg.recordLoginFailure(r.Context(), params.Email, ip)
 		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
 
+	//the plain password is only around at login, so this is where hashes made
+	//with an older algorithm or weaker settings get upgraded
+	if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r.Context(), user, params.Password)
+	}
+
+	//users with two-factor authentication get a challenge instead of tokens
+	mfaEnabled, err := cfg.userHasMFA(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if mfaEnabled {
+		cfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+
+	//a successful login resets the account's failure count. With two-factor
+	//authentication that waits for the second factor.
+	if err := cfg.dbQueries.ClearLoginAttempts(r.Context(), accountLockoutKey(params.Email)); err != nil {
+		log.Printf("Error clearing login attempts: %v", err)
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+//compare a login password with the user's hash. Unknown users and users
+//without a password (they log in through an identity provider) are compared
+//against a dummy hash instead, so they fail as slowly as a wrong password.
+func (cfg *apiConfig) checkLoginPassword(password string, user database.User, found bool) error {
+	if !found || user.HashedPassword == "" {
+		auth.CheckPasswordHash(password, cfg.dummyPasswordHash)
+		return auth.ErrPasswordMismatch
+	}
+	return auth.CheckPasswordHash(password, user.HashedPassword)
+}
+
+//store a new hash of the user's password. Failing is fine, the old hash
+//still works and the next login tries again.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashedPassword, err := cfg.passwordHasher.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing password: %v", err)
+		return
+	}
+	//same password, so the user's sessions stay valid
+	err = cfg.dbQueries.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID: user.ID,
+	})
+	if err != nil {
+		log.Printf("Error storing rehashed password: %v", err)
+	}
+}
+
+//sign an access token carrying the user's token version, roles, Chirpy Red
+//status and every scope
+func (cfg *apiConfig) makeAccessToken(user database.User, expiresIn time.Duration) (string, error) {
+	return cfg.jwtKeys.MakeJWT(user.ID, auth.Claims{
+		TokenVersion: user.TokenVersion,
+		Roles: auth.RolesFor(user.Role),
+		Scopes: auth.AllScopes(),
+		ChirpyRed: user.IsChirpyRed,
+	}, expiresIn)
+}
+
+//create an access token and a new refresh token family for a user that
+//just authenticated, and respond with them
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User){
+	//every way of logging in ends up here, so this is where a pending
+	//account deletion gets cancelled
+	if err := cfg.cancelAccountDeletion(r.Context(), &user); err != nil {
+		log.Printf("Error cancelling account deletion: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
 	//access token expire duration
 	accessTokenExp := 1 *time.Hour
 	//create an access token after successful login
-	token, err := auth.MakeJWT(user.ID, cfg.secret, accessTokenExp)
+	token, err := cfg.makeAccessToken(user, accessTokenExp)
 	if err != nil {
 		log.Printf("Error creating access token: %v", err)
 		respondWithError(w, 400, "Error creating access token")
 		return
 	}
 
-	//create a refresh token with 60 days expiration time
-	refreshTokenExp := (60 * 24) *time.Hour
-
-	refreshToken, err := auth.MakeRefreshToken()
+	//logging in starts a new refresh token family
+	refreshToken, err := cfg.issueRefreshToken(r, cfg.dbQueries, user.ID, uuid.New())
 	if err != nil {
 		log.Printf("Error creating refresh token: %v", err)
 		respondWithError(w, 400, "Error creating refresh token")
 		return
 	}
 
-	//store refresh token in DB
-	_, err = cfg.dbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token: refreshToken,
-		UserID: user.ID,
-		UpdatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(refreshTokenExp),
-	})
-
 	userInfo := UserLogin{
 		ID: user.ID,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 		Email: user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role: user.Role,
 		Token: token,
 		RefreshToken: refreshToken,
 	}
@@ -361,16 +594,40 @@ func(cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request){
 	respondWithJSON(w, 200, userInfo)
 }
 
+//create and store a new refresh token that belongs to the given token family,
+//recording the client that asked for it so it shows up in the session list
+func (cfg *apiConfig) issueRefreshToken(r *http.Request, q *database.Queries, userID, familyID uuid.UUID) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	//only the digest of the token is stored
+	_, err = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		UserID: userID,
+		UpdatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(refreshTokenExp),
+		FamilyID: familyID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
 func(cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request){
 	//get refresh token from token bearer
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		log.Printf("Error getting refresh token from header: %v", err)
-		respondWithError(w, 400, "Error getting refresh token info")
+		respondWithAuthHeaderError(w, err)
 		return
 	}
 
-	info, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), refreshToken)
+	info, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), auth.HashToken(refreshToken))
 	//if the error is because no result found
 	if errors.Is(err, sql.ErrNoRows){
 		log.Printf("No matching refresh token found: %v", err)
@@ -383,15 +640,65 @@ func(cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	//check if the refresh token has expired (revoked_at becomes NOT NULL = valid)
-	if info.RevokedAt.Valid || time.Now().After(info.ExpiresAt){
+	//a revoked token being presented again means it was already rotated (or
+	//logged out) and someone still holds a copy, so kill the whole family
+	if info.RevokedAt.Valid {
+		cfg.revokeTokenFamily(r.Context(), info.FamilyID)
+		respondWithError(w, 401, "refresh token revoked")
+		return
+	}
+
+	if time.Now().After(info.ExpiresAt){
 		log.Printf("Refresh token already expired!")
 		respondWithError(w, 401, "refresh token expired")
 		return
 	}
 
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error rotating refresh token")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	//revoke the presented token, only one concurrent request can win this
+	_, err = qtx.ConsumeRefreshToken(r.Context(), info.TokenHash)
+	if errors.Is(err, sql.ErrNoRows){
+		tx.Rollback()
+		cfg.revokeTokenFamily(r.Context(), info.FamilyID)
+		respondWithError(w, 401, "refresh token revoked")
+		return
+	}
+	if err != nil {
+		log.Printf("Error consuming refresh token: %v", err)
+		respondWithError(w, 500, "Error rotating refresh token")
+		return
+	}
+
+	newRefreshToken, err := cfg.issueRefreshToken(r, qtx, info.UserID, info.FamilyID)
+	if err != nil {
+		log.Printf("Error creating refresh token: %v", err)
+		respondWithError(w, 500, "Error rotating refresh token")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing refresh token rotation: %v", err)
+		respondWithError(w, 500, "Error rotating refresh token")
+		return
+	}
+
 	//create new access token
-	new_token, err := auth.MakeJWT(info.UserID, cfg.secret, time.Hour)
+	//re-read the user so the new token has up to date claims
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), info.UserID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "trouble creating new access token")
+		return
+	}
+	new_token, err := cfg.makeAccessToken(user, time.Hour)
 	if err != nil {
 		log.Printf("Error creating new access token: %v", err)
 		respondWithError(w, 400, "trouble creating new access token")
@@ -399,50 +706,78 @@ func(cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request){
 	}
 
 	type response struct {
-		Token string `json:"token"`
+		Token			string `json:"token"`
+		RefreshToken	string `json:"refresh_token"`
 	}
 
-	resp := response{Token: new_token}
+	resp := response{
+		Token: new_token,
+		RefreshToken: newRefreshToken,
+	}
 
 	respondWithJSON(w, 200, resp)
 }
 
+//invalidate every access token, refresh token and personal access token of a
+//user, used when the password changes
+func revokeUserCredentials(ctx context.Context, q *database.Queries, userID uuid.UUID) error {
+	if err := q.IncrementTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	if err := q.RevokeAllUserPersonalAccessTokens(ctx, userID); err != nil {
+		return err
+	}
+	return q.RevokeAllUserRefreshTokens(ctx, userID)
+}
+
+//revoke every refresh token in a family after a reused token was detected
+func (cfg *apiConfig) revokeTokenFamily(ctx context.Context, familyID uuid.UUID) {
+	log.Printf("Refresh token reuse detected, revoking token family %v", familyID)
+	if err := cfg.dbQueries.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Printf("Error revoking refresh token family: %v", err)
+	}
+}
+
 func(cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request){
 	//get refresh token from token bearer
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		log.Printf("Error getting refresh token from header: %v", err)
-		respondWithError(w, 400, "Error getting refresh token info")
+		respondWithAuthHeaderError(w, err)
 		return
 	}
 
-	//call query to update the token in database to be revoked
-	err = cfg.dbQueries.RevokeRefreshToken(r.Context(), refreshToken)
-	if err != nil {
-		log.Printf("Error revoking refresh token: %v", err)
-		respondWithJSON(w, 400, "Error revoking refresh token")
+	info, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), auth.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows){
+		log.Printf("No matching refresh token found: %v", err)
+		respondWithError(w, 401, "Error invalid token")
 		return
 	}
-
-	respondWithJSON(w, 204, "refresh token has been revoked")
-}
-
-func(cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
-	//validate user
-	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Error getting user token: %v", err)
-		respondWithError(w, 401, "Error validating token")
+		log.Printf("Error getting user from refresh token: %v", err)
+		respondWithError(w, 500, "Error revoking refresh token")
 		return
 	}
-	//validate user token
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+
+	//end the whole session like a detected reuse does, limited to the owner
+	//of the presented token
+	_, err = cfg.dbQueries.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		FamilyID: info.FamilyID,
+		UserID: info.UserID,
+	})
 	if err != nil {
-		log.Printf("Error validating user token: %v", err)
-		respondWithError(w, 401, "Invalid token session")
+		log.Printf("Error revoking refresh token: %v", err)
+		respondWithError(w, 500, "Error revoking refresh token")
 		return
 	}
 
+	respondWithJSON(w, 204, "")
+}
+
+func(cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
 	//decode the new email and password input
 	type parameters struct{
 		Password	string 	`json:"password"`
@@ -457,15 +792,37 @@ func(cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 400, "Error retrieving user data")
+		return
+	}
+	//a different password means every existing session has to go
+	passwordChanged := auth.CheckPasswordHash(params.Password, user.HashedPassword) != nil
+	//only a new password has to meet the policy
+	if passwordChanged && !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(params.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
 		respondWithError(w, 400, "Error hashing password")
 		return
 	}
 
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error updating user data")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
 	//update the user data in database
-	err = cfg.dbQueries.UpdateUser(r.Context(), database.UpdateUserParams{
+	err = qtx.UpdateUser(r.Context(), database.UpdateUserParams{
 		HashedPassword: hashedPassword,
 		Email: params.Email,
 		ID: userID,
@@ -476,6 +833,30 @@ func(cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if passwordChanged {
+		if err := revokeUserCredentials(r.Context(), qtx, userID); err != nil {
+			log.Printf("Error revoking user credentials: %v", err)
+			respondWithError(w, 500, "Error updating user data")
+			return
+		}
+	}
+
+	//UpdateUser has cleared email_verified_at, links sent to the old address
+	//must not verify the new one
+	if params.Email != user.Email {
+		if err := qtx.DeleteUserEmailVerificationTokens(r.Context(), userID); err != nil {
+			log.Printf("Error deleting verification tokens: %v", err)
+			respondWithError(w, 500, "Error updating user data")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing user update: %v", err)
+		respondWithError(w, 500, "Error updating user data")
+		return
+	}
+
 	//get the new user data to print
 	userInfo, err := cfg.dbQueries.UserLogin(r.Context(), params.Email)
 	if err != nil {
@@ -484,30 +865,27 @@ func(cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//a new email address has to be verified again
+	if userInfo.Email != user.Email {
+		if err := cfg.sendVerificationEmail(r.Context(), userInfo); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+	}
+
 	resp := User{
 		ID: userInfo.ID,
 		Email: userInfo.Email,
 		IsChirpyRed: userInfo.IsChirpyRed,
+		EmailVerified: userInfo.EmailVerifiedAt.Valid,
+		Role: userInfo.Role,
 	}
 
 	respondWithJSON(w, 200, resp)
 }
 
 func(cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	//validate user
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting user token: %v", err)
-		respondWithError(w, 401, "Error validating token")
-		return
-	}
-	//validate user token
-	userID, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		log.Printf("Error validating user token: %v", err)
-		respondWithError(w, 401, "Invalid token session")
-		return
-	}
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
 
 	chirpID := r.PathValue("chirpID")
 	
@@ -532,15 +910,14 @@ func(cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	//check if user ID is the same as the chirp's creator
-	if chirp.UserID != userID {
+	//authors can delete their own chirps, moderators anyone's
+	if chirp.UserID != userID && !principal.HasRole(auth.RoleModerator) {
 		log.Printf("User has no access to chirp!")
 		respondWithError(w, 403, "chirp access forbidden")
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirp(r.Context(), id)
-	if err != nil {
+	if err := cfg.deleteChirp(r.Context(), id); err != nil {
 		log.Printf("Error deleting chirp: %v", err)
 		respondWithError(w, 400, "problem deleting chirp")
 		return
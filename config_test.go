@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateUser(t *testing.T){
+	existing := testUser("walt@example.com")
+	const pending = `{"status":"pending_verification"}` + "\n"
+	tests := []struct {
+		name		string
+		email		string
+		password	string
+		dbErr		error
+		want		int
+		wantBody	string
+		wantSubject	string
+	}{
+		{name: "new email", email: "jesse@example.com", password: "a long passphrase", want: 202, wantBody: pending, wantSubject: "Verify your Chirpy email address"},
+		//looks the same as a new email, only the owner's inbox tells them apart
+		{name: "registered email", email: existing.Email, password: "a long passphrase", want: 202, wantBody: pending, wantSubject: "You already have a Chirpy account"},
+		{name: "weak password", email: "jesse@example.com", password: "short", want: 400},
+		{name: "database down", email: "jesse@example.com", password: "a long passphrase", dbErr: errors.New("connection refused"), want: 500},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("UserLogin", func(args []driver.Value) fakeResult {
+			if tc.dbErr != nil {
+				return fakeResult{err: tc.dbErr}
+			}
+			if args[0] != existing.Email {
+				return rowsOf()
+			}
+			return rowsOf(existing)
+		})
+		db.on("CreateUser", func(args []driver.Value) fakeResult {
+			user := testUser(args[1].(string))
+			user.HashedPassword = args[0].(string)
+			return rowsOf(user)
+		})
+		db.on("CreateEmailVerificationToken", func(args []driver.Value) fakeResult {
+			return affected(1)
+		})
+		cfg := db.apiConfig()
+		cfg.passwordPolicy = auth.PasswordPolicy{MinLength: 8, MaxLength: auth.MaxBcryptPasswordBytes}
+		cfg.passwordHasher = auth.BcryptHasher{Cost: bcrypt.MinCost}
+		mail := &fakeMailer{}
+		cfg.mailer = mail
+
+		body := `{"email":"` + tc.email + `","password":"` + tc.password + `"}`
+		w := httptest.NewRecorder()
+		cfg.handlerCreateUser(w, httptest.NewRequest("POST", "/api/users", strings.NewReader(body)))
+		if w.Code != tc.want {
+			t.Errorf("handlerCreateUser %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+		if tc.wantBody != "" && w.Body.String() != tc.wantBody {
+			t.Errorf("handlerCreateUser %s: want body %q, got %q", tc.name, tc.wantBody, w.Body)
+		}
+
+		//the work is done by the time the response is sent
+		if tc.wantSubject == "" {
+			if len(mail.sent) != 0 {
+				t.Errorf("handlerCreateUser %s: want no email, got %v", tc.name, mail.sent)
+			}
+			continue
+		}
+		if len(mail.sent) != 1 || mail.sent[0].To != tc.email || mail.sent[0].Subject != tc.wantSubject {
+			t.Errorf("handlerCreateUser %s: want %q sent to %s, got %v", tc.name, tc.wantSubject, tc.email, mail.sent)
+		}
+		created := db.called("CreateUser")
+		if tc.email == existing.Email {
+			if len(created) != 0 {
+				t.Errorf("handlerCreateUser %s: want no new account, got %v", tc.name, created)
+			}
+			continue
+		}
+		if len(created) != 1 || auth.CheckPasswordHash(tc.password, created[0][0].(string)) != nil {
+			t.Errorf("handlerCreateUser %s: want an account with the hashed password, got %v", tc.name, created)
+		}
+	}
+}
+
+func TestGetChirpsSortAndFilter(t *testing.T){
+	author := uuid.New()
+	tests := []struct {
+		name		string
+		query		string
+		wantQuery	string
+		wantAuthor	driver.Value
+	}{
+		{name: "default", query: "", wantQuery: "ListChirps"},
+		{name: "asc", query: "sort=asc", wantQuery: "ListChirps"},
+		{name: "desc", query: "sort=desc", wantQuery: "ListChirpsDesc"},
+		{name: "author", query: "author_id=" + author.String(), wantQuery: "ListChirps", wantAuthor: author.String()},
+		{name: "author desc", query: "author_id=" + author.String() + "&sort=desc", wantQuery: "ListChirpsDesc", wantAuthor: author.String()},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		for _, name := range []string{"ListChirps", "ListChirpsDesc"} {
+			db.on(name, func(args []driver.Value) fakeResult {
+				return rowsOf()
+			})
+		}
+		cfg := db.apiConfig()
+
+		w := httptest.NewRecorder()
+		cfg.handlerGetChirps(w, httptest.NewRequest("GET", "/api/chirps?"+tc.query, nil))
+		if w.Code != 200 {
+			t.Errorf("handlerGetChirps %s: want 200, got %d %s", tc.name, w.Code, w.Body)
+			continue
+		}
+		//sorting and filtering both happen in the one query
+		calls := db.called(tc.wantQuery)
+		if len(calls) != 1 || len(db.called("ListChirps"))+len(db.called("ListChirpsDesc")) != 1 {
+			t.Errorf("handlerGetChirps %s: want only %s, got %v", tc.name, tc.wantQuery, db.calls)
+			continue
+		}
+		if calls[0][0] != tc.wantAuthor {
+			t.Errorf("handlerGetChirps %s: want author filter %v, got %v", tc.name, tc.wantAuthor, calls[0][0])
+		}
+	}
+}
+
+//posting goes through the same body rules as editing, see TestUpdateChirp
+func TestCreateChirpsBody(t *testing.T){
+	userID := uuid.New()
+	tests := []struct {
+		name		string
+		body		string
+		want		int
+		wantBody	string
+	}{
+		{name: "clean", body: "hello world", want: 201, wantBody: "hello world"},
+		{name: "profanity and control characters", body: "a Kerfuffle\x1b after all", want: 201, wantBody: "a **** after all"},
+		{name: "empty", body: "", want: 400},
+		{name: "only control characters", body: "\x00\x07", want: 400},
+		{name: "too long", body: strings.Repeat("a", maxChirpLength+1), want: 400},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("CreateChirps", func(args []driver.Value) fakeResult {
+			return rowsOf(database.CreateChirpsRow{
+				ID: uuid.New(),
+				Body: args[0].(string),
+				UserID: userID,
+			})
+		})
+		cfg := db.apiConfig()
+
+		body, _ := json.Marshal(map[string]string{"body": tc.body})
+		w := httptest.NewRecorder()
+		cfg.handlerCreateChirps(w, authedRequest("POST", "/api/chirps", strings.NewReader(string(body)), userID))
+		if w.Code != tc.want {
+			t.Errorf("handlerCreateChirps %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+		created := db.called("CreateChirps")
+		if tc.want != 201 {
+			if len(created) != 0 {
+				t.Errorf("handlerCreateChirps %s: want nothing stored, got %v", tc.name, created)
+			}
+			continue
+		}
+		if len(created) != 1 || created[0][0] != tc.wantBody {
+			t.Errorf("handlerCreateChirps %s: want body %q stored, got %v", tc.name, tc.wantBody, created)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//fakeDB stands in for Postgres in handler tests. It answers every sqlc query
+//by name with what the test set up and records the queries that ran.
+type fakeDB struct {
+	t		*testing.T
+	mu		sync.Mutex
+	queries	map[string]func(args []driver.Value) fakeResult
+	calls	[]fakeCall
+}
+
+//what a query returns: rows for queries, affected for execs
+type fakeResult struct {
+	rows		[][]driver.Value
+	affected	int64
+	err			error
+}
+
+type fakeCall struct {
+	name	string
+	args	[]driver.Value
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	return &fakeDB{t: t, queries: map[string]func(args []driver.Value) fakeResult{}}
+}
+
+//on makes the query called name answer with fn
+func (db *fakeDB) on(name string, fn func(args []driver.Value) fakeResult) {
+	db.queries[name] = fn
+}
+
+//apiConfig returns a config whose database is db
+func (db *fakeDB) apiConfig() *apiConfig {
+	sqlDB := sql.OpenDB(fakeConnector{db})
+	db.t.Cleanup(func(){ sqlDB.Close() })
+	return &apiConfig{db: sqlDB, dbQueries: database.New(sqlDB)}
+}
+
+//called returns the arguments of every call of the query called name.
+//Committed transactions show up as COMMIT.
+func (db *fakeDB) called(name string) [][]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var args [][]driver.Value
+	for _, c := range db.calls {
+		if c.name == name {
+			args = append(args, c.args)
+		}
+	}
+	return args
+}
+
+func (db *fakeDB) run(query string, args []driver.NamedValue) fakeResult {
+	//sqlc starts every query with "-- name: Name :kind"
+	name, _, _ := strings.Cut(strings.TrimPrefix(query, "-- name: "), " ")
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+
+	db.mu.Lock()
+	db.calls = append(db.calls, fakeCall{name: name, args: values})
+	fn, ok := db.queries[name]
+	db.mu.Unlock()
+	if !ok {
+		db.t.Errorf("fakeDB: unexpected query %s", name)
+		return fakeResult{err: errors.New("fakeDB: unexpected query " + name)}
+	}
+	return fn(values)
+}
+
+//authedRequest returns a request by userID, as RequireAuth passes it on
+func authedRequest(method, target string, body io.Reader, userID uuid.UUID) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	principal := &auth.Principal{
+		UserID: userID,
+		Claims: &auth.Claims{},
+		Scopes: auth.AllScopes(),
+	}
+	return r.WithContext(auth.ContextWithPrincipal(r.Context(), principal))
+}
+
+//rowsOf returns one row per value. Structs like the sqlc row types have their
+//fields in column order and give a column each, other values are one column.
+func rowsOf(values ...any) fakeResult {
+	result := fakeResult{rows: [][]driver.Value{}}
+	for _, v := range values {
+		rv := reflect.ValueOf(v)
+		_, isValuer := v.(driver.Valuer)
+		_, isTime := v.(time.Time)
+		if rv.Kind() != reflect.Struct || isValuer || isTime {
+			result.rows = append(result.rows, []driver.Value{fakeValue(v)})
+			continue
+		}
+		row := make([]driver.Value, rv.NumField())
+		for i := range row {
+			row[i] = fakeValue(rv.Field(i).Interface())
+		}
+		result.rows = append(result.rows, row)
+	}
+	return result
+}
+
+//affected returns the result of an exec changing n rows
+func affected(n int64) fakeResult {
+	return fakeResult{affected: n}
+}
+
+func fakeValue(v any) driver.Value {
+	switch v := v.(type) {
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			panic(err)
+		}
+		return value
+	case int32:
+		return int64(v)
+	case []string:
+		return fakeValue(pq.StringArray(v))
+	}
+	return v
+}
+
+type fakeConnector struct {
+	db	*fakeDB
+}
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeConn{c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("fakeDB: connect through fakeConnector")
+}
+
+type fakeConn struct {
+	db	*fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakeDB: prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{c.db}, nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{rows: result.rows}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(result.affected), nil
+}
+
+type fakeTx struct {
+	db	*fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.calls = append(tx.db.calls, fakeCall{name: "COMMIT"})
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeRows struct {
+	rows	[][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -1,32 +1,47 @@
 package auth
 
 import (
+	"context"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"time"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"fmt"
 	"strings"
 	"net/http"
 	"crypto/rand"
 	"encoding/hex"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 )
 
+//HashPassword hashes a password with bcrypt at the default cost.
+//The server hashes with its configured PasswordHasher instead.
 func HashPassword(password string) (string, error){
 
-	hashed_pw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed_pw, err := BcryptHasher{Cost: bcrypt.DefaultCost}.Hash(password)
 	if err != nil {
 		log.Printf("Error generating password: %v", err)
 		return "", err
 	}
 
-	str_hashed_pw := string(hashed_pw)
-	return str_hashed_pw, nil
+	return hashed_pw, nil
 }
 
+//CheckPasswordHash checks a password against a hash made by any of the
+//supported hashers, picking the algorithm from the hash itself
 func CheckPasswordHash(password, hash string) error {
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+	var err error
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		err = checkArgon2idHash(password, hash)
+	case isBcryptHash(hash):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	default:
+		err = ErrUnknownHash
+	}
+	if err != nil {
 		log.Printf("Error comparing hash and password")
 		return err
 	}
@@ -34,91 +49,144 @@ func CheckPasswordHash(password, hash string) error {
 	return nil
 }
 
+//MakeJWT signs an HS256 access token with a shared secret
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error){
-	//create custom claims for token creation
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:		"chirpy",
-		IssuedAt:	jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt:	jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:	userID.String(),
-		})
-	
-	//sign the token
-	tokenString, err := token.SignedString([]byte(tokenSecret))
-	if err != nil {
-		log.Printf("Error signing token: %v", err)
-		return "", err
-	}
-	return tokenString, nil
+	return NewHMACKeyRing(tokenSecret).MakeJWT(userID, Claims{}, expiresIn)
+}
+
+//ValidateJWT validates an HS256 access token signed with a shared secret
+func ValidateJWT(tokenString, tokenSecret string) (*Claims, error){
+	return NewHMACKeyRing(tokenSecret).ValidateJWT(context.Background(), tokenString)
+}
+
+//errors returned when reading the Authorization header
+var (
+	ErrNoAuthHeader = errors.New("no authorization header")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+	ErrWrongAuthScheme = errors.New("wrong authorization scheme")
+)
+
+//authorization schemes understood by ParseAuthorization
+const (
+	SchemeBearer = "Bearer"
+	SchemeAPIKey = "ApiKey"
+	SchemeBasic = "Basic"
+)
+
+//Credentials are the contents of an Authorization header.
+//Token is set for Bearer and ApiKey, Username and Password for Basic.
+type Credentials struct {
+	Scheme		string
+	Token		string
+	Username	string
+	Password	string
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error){
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&jwt.RegisteredClaims{},
-		func(t *jwt.Token) (any, error){
-			//ensure HMAC (HS256 family)
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.Printf("Error ensuring HMAC signing method")
-				return nil, fmt.Errorf("unexpected signing method: %T", t.Method)
-			}
-			return []byte(tokenSecret), nil
-		})
-	//check if theres any error or token invalid
-	if err != nil || !token.Valid {
-		log.Printf("Error parsing token claim: %v", err)
-		return uuid.Nil, fmt.Errorf("invalid token")
+//ParseAuthorization reads a "SCHEME CREDENTIALS" Authorization header. The
+//scheme is matched case-insensitively and returned in its canonical form.
+func ParseAuthorization(headers http.Header) (Credentials, error) {
+	creds := Credentials{}
+
+	auth_header := headers.Get("Authorization")
+	//if the authorization header doesn't exist return an error
+	if strings.TrimSpace(auth_header) == "" {
+		return creds, ErrNoAuthHeader
+	}
+	//exactly a scheme and the credentials, "Bearer" on its own is malformed
+	auth_headers := strings.Fields(auth_header)
+	if len(auth_headers) != 2 {
+		return creds, ErrMalformedAuthHeader
 	}
-	//check the claims
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
-	if !ok {
-		return uuid.Nil, fmt.Errorf("invalid claims type")
+
+	scheme, value := auth_headers[0], auth_headers[1]
+	switch {
+	case strings.EqualFold(scheme, SchemeBearer):
+		creds.Scheme = SchemeBearer
+		creds.Token = value
+	case strings.EqualFold(scheme, SchemeAPIKey):
+		creds.Scheme = SchemeAPIKey
+		creds.Token = value
+	case strings.EqualFold(scheme, SchemeBasic):
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return creds, ErrMalformedAuthHeader
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return creds, ErrMalformedAuthHeader
+		}
+		creds.Scheme = SchemeBasic
+		creds.Username = username
+		creds.Password = password
+	default:
+		return creds, fmt.Errorf("%w: %q", ErrWrongAuthScheme, scheme)
 	}
+	return creds, nil
+}
 
-	id, err := uuid.Parse(claims.Subject)
+//read credentials of one expected scheme
+func parseAuthorizationScheme(headers http.Header, scheme string) (Credentials, error) {
+	creds, err := ParseAuthorization(headers)
 	if err != nil {
-		return uuid.Nil, err
+		return creds, err
+	}
+	if creds.Scheme != scheme {
+		return creds, fmt.Errorf("%w: want %s, got %s", ErrWrongAuthScheme, scheme, creds.Scheme)
 	}
-	return id, nil
+	return creds, nil
 }
 
+//GetBearerToken returns the token from an "Authorization: Bearer TOKEN" header
 func GetBearerToken(headers http.Header) (string, error){
-	//get authorization header
-	auth_header := headers.Get("Authorization")
-	//if the authorization header doesn't exist return an error
-	if auth_header == "" {
-		log.Printf("No Authorization field")
-		return "", fmt.Errorf("No authorization field found")
+	creds, err := parseAuthorizationScheme(headers, SchemeBearer)
+	if err != nil {
+		return "", err
 	}
-	//only take the TOKEN_STRING from the "Bearer TOKEN_STRING" format
-	auth_headers := strings.Fields(auth_header)
-	token_string := auth_headers[1]
-	return token_string, nil
+	return creds.Token, nil
 }
 
 func MakeRefreshToken() (string, error) {
-	//generate random key and token
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+	key_string, err := MakeOpaqueToken()
 	if err != nil {
 		log.Printf("Error creating refresh token: %v", err)
 		return "", fmt.Errorf("Failed creating refresh token")
 	}
-
-	key_string := hex.EncodeToString(key)
 	return key_string, nil
 }
 
+//MakeOpaqueToken returns 256 random bits as hex, for tokens that are only
+//looked up in the database (refresh tokens, email links, ...)
+func MakeOpaqueToken() (string, error) {
+	//generate random key and token
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+//HashToken returns the SHA-256 digest of an opaque token as hex. Only the
+//digest is stored so a database leak does not hand out usable tokens.
+//The tokens are 256 bits of randomness, so a plain (unsalted) hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//GetAPIKey returns the key from an "Authorization: ApiKey KEY" header
 func GetAPIKey(headers http.Header) (string, error) {
-	//get auth header
-	auth_header := headers.Get("Authorization")
-	//if the authorization header doesn't exist return an error
-	if auth_header == "" {
-		log.Printf("No Authorization field")
-		return "", fmt.Errorf("No authorization field found")
+	creds, err := parseAuthorizationScheme(headers, SchemeAPIKey)
+	if err != nil {
+		return "", err
 	}
-	//only take the key part from "Authorization: THE_KEY" format
-	auth_headers := strings.Fields(auth_header)
-	apiKey := auth_headers[1]
-	return apiKey, nil
-}
\ No newline at end of file
+	return creds.Token, nil
+}
+
+//GetBasicAuth returns the username and password from a Basic header
+func GetBasicAuth(headers http.Header) (string, string, error) {
+	creds, err := parseAuthorizationScheme(headers, SchemeBasic)
+	if err != nil {
+		return "", "", err
+	}
+	return creds.Username, creds.Password, nil
+}
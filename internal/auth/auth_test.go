@@ -5,6 +5,7 @@ import (
 	"time"
 	"github.com/google/uuid"
 	"net/http"
+	"errors"
 )
 
 //check success path
@@ -60,12 +61,12 @@ func TestMakeAndValidateJWT_Succeeds(t *testing.T){
 		t.Fatalf("MakeJWT err: %v", err)
 	}
 
-	gotID, err := ValidateJWT(tok, secret)
+	claims, err := ValidateJWT(tok, secret)
 	if err != nil {
 		t.Fatalf("ValidateJWT err: %v", err)
 	}
-	if gotID != userID{
-		t.Fatalf("want %v, got %v", userID, gotID)
+	if claims.Subject != userID.String(){
+		t.Fatalf("want %v, got %v", userID, claims.Subject)
 	}
 }
 
@@ -122,4 +123,98 @@ func TestGetBearerToken_empty (t *testing.T){
 	if err == nil {
 		t.Fatalf("expected error for empty bearer token")
 	}
-}
\ No newline at end of file
+}
+
+func TestParseAuthorization(t *testing.T){
+	tests := []struct {
+		name	string
+		header	string
+		want	Credentials
+		wantErr	error
+	}{
+		{"bearer", "Bearer abc", Credentials{Scheme: SchemeBearer, Token: "abc"}, nil},
+		{"bearer lowercase", "bearer abc", Credentials{Scheme: SchemeBearer, Token: "abc"}, nil},
+		{"bearer extra spaces", "  Bearer   abc  ", Credentials{Scheme: SchemeBearer, Token: "abc"}, nil},
+		{"api key", "ApiKey k3y", Credentials{Scheme: SchemeAPIKey, Token: "k3y"}, nil},
+		{"api key uppercase", "APIKEY k3y", Credentials{Scheme: SchemeAPIKey, Token: "k3y"}, nil},
+		{"basic", "Basic dXNlcjpwYTpzcw==", Credentials{Scheme: SchemeBasic, Username: "user", Password: "pa:ss"}, nil},
+		{"missing", "", Credentials{}, ErrNoAuthHeader},
+		{"blank", "   ", Credentials{}, ErrNoAuthHeader},
+		{"scheme only", "Bearer", Credentials{}, ErrMalformedAuthHeader},
+		{"no scheme", "abc", Credentials{}, ErrMalformedAuthHeader},
+		{"too many parts", "Bearer abc def", Credentials{}, ErrMalformedAuthHeader},
+		{"basic not base64", "Basic !!!", Credentials{}, ErrMalformedAuthHeader},
+		{"basic without colon", "Basic dXNlcg==", Credentials{}, ErrMalformedAuthHeader},
+		{"unknown scheme", "Digest abc", Credentials{}, ErrWrongAuthScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			headers := make(http.Header)
+			if tt.header != "" {
+				headers.Set("Authorization", tt.header)
+			}
+
+			got, err := ParseAuthorization(headers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCredentialsWrongScheme(t *testing.T){
+	tests := []struct {
+		name	string
+		header	string
+		get		func(http.Header) error
+	}{
+		{"basic as bearer", "Basic dXNlcjpwYXNz", func(h http.Header) error { _, err := GetBearerToken(h); return err }},
+		{"api key as bearer", "ApiKey abc", func(h http.Header) error { _, err := GetBearerToken(h); return err }},
+		{"bearer as api key", "Bearer abc", func(h http.Header) error { _, err := GetAPIKey(h); return err }},
+		{"bearer as basic", "Bearer abc", func(h http.Header) error { _, _, err := GetBasicAuth(h); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			headers := make(http.Header)
+			headers.Set("Authorization", tt.header)
+			if err := tt.get(headers); !errors.Is(err, ErrWrongAuthScheme) {
+				t.Fatalf("err = %v, want ErrWrongAuthScheme", err)
+			}
+		})
+	}
+}
+
+func TestGetBearerToken_schemeOnly(t *testing.T){
+	headers := make(http.Header)
+	headers.Set("Authorization", "Bearer")
+
+	//used to panic indexing the missing token
+	if _, err := GetBearerToken(headers); !errors.Is(err, ErrMalformedAuthHeader) {
+		t.Fatalf("err = %v, want ErrMalformedAuthHeader", err)
+	}
+}
+func TestHashToken(t *testing.T){
+	tok, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken err: %v", err)
+	}
+
+	digest := HashToken(tok)
+	if digest == tok {
+		t.Fatalf("expected digest to differ from the raw token")
+	}
+	if len(digest) != 64 {
+		t.Fatalf("want 64 hex chars, got %d", len(digest))
+	}
+	if HashToken(tok) != digest {
+		t.Fatalf("expected hashing the same token twice to match")
+	}
+	//known SHA-256 of "abc"
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//ErrTokenRevoked is returned by ValidateJWT for access tokens on the denylist
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+//RevokedToken is a denylist entry. Entries are only needed until the token
+//would have expired anyway.
+type RevokedToken struct {
+	JTI			string
+	ExpiresAt	time.Time
+	RevokedAt	time.Time
+}
+
+//DenylistStore persists revoked access tokens so every server sees them
+type DenylistStore interface {
+	RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error
+	//unexpired entries revoked at or after since
+	RevokedAccessTokensSince(ctx context.Context, since time.Time) ([]RevokedToken, error)
+	DeleteExpiredRevokedAccessTokens(ctx context.Context) (int64, error)
+}
+
+//Denylist keeps the jti of every revoked, unexpired access token in memory,
+//so checking a token doesn't cost a query. Tokens revoked on this server are
+//denied right away, ones revoked on other servers once Sync picks them up.
+type Denylist struct {
+	store	DenylistStore
+
+	mu		sync.RWMutex
+	//jti -> expiry of the token
+	entries	map[string]time.Time
+	//newest RevokedAt seen in the store
+	synced	time.Time
+}
+
+//entries committed slightly out of order are still picked up by the next sync
+const denylistSyncOverlap = time.Minute
+
+func NewDenylist(store DenylistStore) *Denylist {
+	return &Denylist{store: store, entries: map[string]time.Time{}}
+}
+
+//Revoke denylists a token until it expires
+func (d *Denylist) Revoke(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token has no jti or expiry")
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return err
+	}
+	if err := d.store.RevokeAccessToken(ctx, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.entries[claims.ID] = claims.ExpiresAt.Time
+	d.mu.Unlock()
+	return nil
+}
+
+//IsRevoked reports whether the token with jti is on the denylist
+func (d *Denylist) IsRevoked(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.entries[jti]
+	return ok
+}
+
+//Sync loads the entries added to the store since the last sync and forgets
+//expired ones
+func (d *Denylist) Sync(ctx context.Context) error {
+	d.mu.RLock()
+	since := d.synced
+	d.mu.RUnlock()
+	if !since.IsZero() {
+		since = since.Add(-denylistSyncOverlap)
+	}
+
+	revoked, err := d.store.RevokedAccessTokensSince(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range revoked {
+		d.entries[r.JTI] = r.ExpiresAt
+		if r.RevokedAt.After(d.synced) {
+			d.synced = r.RevokedAt
+		}
+	}
+	for jti, exp := range d.entries {
+		if !exp.After(now) {
+			delete(d.entries, jti)
+		}
+	}
+	return nil
+}
+
+//Run syncs the denylist every interval and deletes expired entries from the
+//store, until ctx is done
+func (d *Denylist) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := d.Sync(ctx); err != nil {
+			log.Printf("Error syncing access token denylist: %v", err)
+		}
+		if _, err := d.store.DeleteExpiredRevokedAccessTokens(ctx); err != nil {
+			log.Printf("Error deleting expired denylist entries: %v", err)
+		}
+	}
+}
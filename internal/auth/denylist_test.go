@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+//memoryDenylistStore is a DenylistStore shared by several Denylists, like
+//the database is shared by several servers
+type memoryDenylistStore struct {
+	mu		sync.Mutex
+	revoked	[]RevokedToken
+}
+
+func (s *memoryDenylistStore) RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = append(s.revoked, RevokedToken{JTI: jti, ExpiresAt: expiresAt, RevokedAt: time.Now()})
+	return nil
+}
+
+func (s *memoryDenylistStore) RevokedAccessTokensSince(ctx context.Context, since time.Time) ([]RevokedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []RevokedToken
+	for _, r := range s.revoked {
+		if !r.RevokedAt.Before(since) && r.ExpiresAt.After(time.Now()) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryDenylistStore) DeleteExpiredRevokedAccessTokens(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.revoked[:0]
+	for _, r := range s.revoked {
+		if r.ExpiresAt.After(time.Now()) {
+			kept = append(kept, r)
+		}
+	}
+	deleted := int64(len(s.revoked) - len(kept))
+	s.revoked = kept
+	return deleted, nil
+}
+
+func TestKeyRing_Denylist(t *testing.T){
+	store := &memoryDenylistStore{}
+	denylist := NewDenylist(store)
+	ring := NewHMACKeyRing("secret")
+	ring.SetDenylist(denylist)
+
+	userID := uuid.New()
+	tok, err := ring.MakeJWT(userID, Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+	claims, err := ring.ValidateJWT(context.Background(), tok)
+	if err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+
+	if err := denylist.Revoke(context.Background(), claims); err != nil {
+		t.Fatalf("Revoke err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("want ErrTokenRevoked after logout, got %v", err)
+	}
+
+	//other tokens of the same user still work
+	other, _ := ring.MakeJWT(userID, Claims{}, time.Minute)
+	if _, err := ring.ValidateJWT(context.Background(), other); err != nil {
+		t.Fatalf("ValidateJWT of another token err: %v", err)
+	}
+}
+
+func TestDenylist_SyncAcrossServers(t *testing.T){
+	store := &memoryDenylistStore{}
+	server1 := NewDenylist(store)
+	server2 := NewDenylist(store)
+
+	claims := &Claims{}
+	claims.ID = uuid.NewString()
+	claims.Subject = uuid.NewString()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	if err := server1.Revoke(context.Background(), claims); err != nil {
+		t.Fatalf("Revoke err: %v", err)
+	}
+	if !server1.IsRevoked(claims.ID) {
+		t.Fatalf("token should be revoked on the server that revoked it")
+	}
+	if server2.IsRevoked(claims.ID) {
+		t.Fatalf("token shouldn't be known to the other server before a sync")
+	}
+
+	if err := server2.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync err: %v", err)
+	}
+	if !server2.IsRevoked(claims.ID) {
+		t.Fatalf("token should be revoked on the other server after a sync")
+	}
+}
+
+func TestDenylist_ForgetsExpiredEntries(t *testing.T){
+	store := &memoryDenylistStore{}
+	denylist := NewDenylist(store)
+
+	claims := &Claims{}
+	claims.ID = uuid.NewString()
+	claims.Subject = uuid.NewString()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second))
+
+	if err := denylist.Revoke(context.Background(), claims); err != nil {
+		t.Fatalf("Revoke err: %v", err)
+	}
+	if err := denylist.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync err: %v", err)
+	}
+	if denylist.IsRevoked(claims.ID) {
+		t.Fatalf("expired entry should have been dropped")
+	}
+	if n, _ := store.DeleteExpiredRevokedAccessTokens(context.Background()); n != 1 {
+		t.Fatalf("want 1 expired entry deleted from the store, got %d", n)
+	}
+}
+
+func TestKeyRing_DenylistRejectsTokensWithoutJTI(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	ring.SetDenylist(NewDenylist(&memoryDenylistStore{}))
+
+	//a token like the ones issued before tokens had a jti
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: DefaultIssuer,
+			Audience: jwt.ClaimStrings{DefaultAudience},
+			Subject: uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("signing err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("want ErrTokenRevoked for a token without jti, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ErrPasswordMismatch is returned when a password doesn't match its hash
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+//ErrUnknownHash is returned for stored hashes in a format we can't check
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+//PasswordHasher creates password hashes in PHC string format, which carries
+//the algorithm and its parameters with the hash so older hashes can still be
+//checked after the settings change
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	//NeedsRehash reports whether a stored hash was made with another
+	//algorithm or other parameters than the hasher currently uses
+	NeedsRehash(hash string) bool
+}
+
+//Argon2idHasher hashes passwords with argon2id.
+//Memory is in KiB.
+type Argon2idHasher struct {
+	Memory		uint32
+	Iterations	uint32
+	Parallelism	uint8
+	SaltLength	uint32
+	KeyLength	uint32
+}
+
+//DefaultArgon2idHasher returns an argon2id hasher with the parameters
+//recommended by OWASP: 64 MiB of memory, 3 passes and 2 lanes
+func DefaultArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{
+		Memory: 64 * 1024,
+		Iterations: 3,
+		Parallelism: 2,
+		SaltLength: 16,
+		KeyLength: 32,
+	}
+}
+
+//argon2idParams are the parameters read back from a stored argon2id hash
+type argon2idParams struct {
+	memory		uint32
+	iterations	uint32
+	parallelism	uint8
+	salt		[]byte
+	key			[]byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	p, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory ||
+		p.iterations != h.Iterations ||
+		p.parallelism != h.Parallelism ||
+		uint32(len(p.salt)) != h.SaltLength ||
+		uint32(len(p.key)) != h.KeyLength
+}
+
+//parse a hash of the form $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func parseArgon2idHash(hash string) (argon2idParams, error) {
+	p := argon2idParams{}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return p, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	//argon2 panics on zero passes or lanes
+	if p.iterations < 1 || p.parallelism < 1 {
+		return p, errors.New("invalid argon2id parameters")
+	}
+
+	var err error
+	p.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	p.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	if len(p.key) == 0 {
+		return p, errors.New("empty argon2id key")
+	}
+
+	return p, nil
+}
+
+func checkArgon2idHash(password, hash string) error {
+	p, err := parseArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+//BcryptHasher hashes passwords with bcrypt. Its $2a$ hashes predate the PHC
+//format but already carry the cost, so they're stored as they are.
+type BcryptHasher struct {
+	Cost	int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
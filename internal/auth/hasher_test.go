@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+//small parameters keep the tests fast
+var testArgon2idHasher = Argon2idHasher{
+	Memory: 1024,
+	Iterations: 1,
+	Parallelism: 1,
+	SaltLength: 16,
+	KeyLength: 32,
+}
+
+func TestArgon2idHashAndCheck(t *testing.T) {
+	hash, err := testArgon2idHasher.Hash("Test12345")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash %q is not in PHC format", hash)
+	}
+
+	if err := CheckPasswordHash("Test12345", hash); err != nil {
+		t.Errorf("Correct password rejected: %v", err)
+	}
+	if err := CheckPasswordHash("Test1234", hash); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Wrong password: got %v, want ErrPasswordMismatch", err)
+	}
+}
+
+func TestArgon2idSaltedHashes(t *testing.T) {
+	hash1, _ := testArgon2idHasher.Hash("Test3")
+	hash2, _ := testArgon2idHasher.Hash("Test3")
+	if hash1 == hash2 {
+		t.Errorf("Two hashes of the same password are equal")
+	}
+}
+
+func TestCheckPasswordHashFormats(t *testing.T) {
+	bcryptHash, err := BcryptHasher{Cost: 4}.Hash("Test12345")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if err := CheckPasswordHash("Test12345", bcryptHash); err != nil {
+		t.Errorf("Correct password rejected for bcrypt hash: %v", err)
+	}
+
+	tests := []struct {
+		name	string
+		hash	string
+	}{
+		{"plain text", "Test12345"},
+		{"unknown algorithm", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5"},
+		{"missing key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"bad version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5"},
+		{"bad parameters", "$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5"},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPasswordHash("Test12345", tt.hash); err == nil {
+				t.Errorf("Malformed hash %q was accepted", tt.hash)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argonHash, _ := testArgon2idHasher.Hash("Test12345")
+	bcryptHash, _ := BcryptHasher{Cost: 4}.Hash("Test12345")
+
+	stronger := testArgon2idHasher
+	stronger.Iterations = 2
+
+	tests := []struct {
+		name	string
+		hasher	PasswordHasher
+		hash	string
+		want	bool
+	}{
+		{"argon2id same parameters", testArgon2idHasher, argonHash, false},
+		{"argon2id new parameters", stronger, argonHash, true},
+		{"argon2id from bcrypt", testArgon2idHasher, bcryptHash, true},
+		{"bcrypt same cost", BcryptHasher{Cost: 4}, bcryptHash, false},
+		{"bcrypt new cost", BcryptHasher{Cost: 5}, bcryptHash, true},
+		{"bcrypt from argon2id", BcryptHasher{Cost: 4}, argonHash, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
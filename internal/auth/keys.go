@@ -0,0 +1,403 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+//a single asymmetric key identified by its kid
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer //nil for verification-only keys
+	public  crypto.PublicKey
+}
+
+//KeyRing holds the key used to sign new access tokens and every key that is
+//still accepted when verifying them. Keeping retired keys in the ring lets
+//tokens signed before a rotation stay valid until they expire.
+type KeyRing struct {
+	//legacy HS256 secret, only used when set
+	secret  []byte
+	signing *jwtKey
+	keys    map[string]*jwtKey
+	//reports the current token version of a user, nil skips the check
+	tokenVersion TokenVersionFunc
+	//revoked access tokens, nil skips the check
+	denylist *Denylist
+	//iss and aud of issued tokens, both are required when validating
+	issuer   string
+	audience string
+	//clock skew allowed when checking exp, nbf and iat
+	leeway time.Duration
+}
+
+//defaults for the iss and aud claims and the allowed clock skew
+const (
+	DefaultIssuer   = "chirpy"
+	DefaultAudience = "chirpy-api"
+	DefaultLeeway   = 30 * time.Second
+)
+
+//Claims are the claims of a chirpy access token. Roles, scopes and the Chirpy
+//Red status are copied from the user when the token is issued, so handlers
+//can check them without a database lookup. They can be up to one access
+//token lifetime out of date.
+type Claims struct {
+	jwt.RegisteredClaims
+	//bumped whenever the user's password changes, so tokens issued
+	//before the change stop validating
+	TokenVersion int32    `json:"ver"`
+	Roles        []string `json:"roles,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	ChirpyRed    bool     `json:"chirpy_red"`
+}
+
+//UserID returns the user the token was issued to
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+//HasRole reports whether the token carries role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//TokenVersionFunc returns the current token version of a user
+type TokenVersionFunc func(ctx context.Context, userID uuid.UUID) (int32, error)
+
+//SetTokenVersionFunc makes ValidateJWT reject tokens whose version does not
+//match the user's current token version.
+func (k *KeyRing) SetTokenVersionFunc(fn TokenVersionFunc) {
+	k.tokenVersion = fn
+}
+
+//SetDenylist makes ValidateJWT reject tokens that were revoked before they
+//expired. Tokens without a jti can't be revoked and are rejected too.
+func (k *KeyRing) SetDenylist(d *Denylist) {
+	k.denylist = d
+}
+
+//NewKeyRing returns an empty key ring. Add keys with AddSigningKey and
+//AddVerificationKey.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys:     map[string]*jwtKey{},
+		issuer:   DefaultIssuer,
+		audience: DefaultAudience,
+		leeway:   DefaultLeeway,
+	}
+}
+
+//SetIssuer sets the iss claim of new tokens and the issuer tokens must have
+func (k *KeyRing) SetIssuer(issuer string) {
+	k.issuer = issuer
+}
+
+//SetAudience sets the aud claim of new tokens and the audience tokens must have
+func (k *KeyRing) SetAudience(audience string) {
+	k.audience = audience
+}
+
+//SetLeeway sets how much clock skew between servers is tolerated
+func (k *KeyRing) SetLeeway(leeway time.Duration) {
+	k.leeway = leeway
+}
+
+//NewHMACKeyRing returns a key ring that signs and verifies with the shared
+//HS256 secret only.
+func NewHMACKeyRing(secret string) *KeyRing {
+	k := NewKeyRing()
+	k.secret = []byte(secret)
+	return k
+}
+
+//AddSigningKey adds an RSA or Ed25519 private key and makes it the key used
+//for new tokens. The previous signing key stays in the ring for verification.
+func (k *KeyRing) AddSigningKey(kid string, key crypto.Signer) error {
+	if err := k.AddVerificationKey(kid, key.Public()); err != nil {
+		return err
+	}
+	k.keys[kid].private = key
+	k.signing = k.keys[kid]
+	return nil
+}
+
+//AddVerificationKey adds a public key that is accepted when validating tokens
+//but never used for signing.
+func (k *KeyRing) AddVerificationKey(kid string, pub crypto.PublicKey) error {
+	if kid == "" {
+		return fmt.Errorf("key id must not be empty")
+	}
+	if _, ok := k.keys[kid]; ok {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+
+	var method jwt.SigningMethod
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("unsupported key type %T for key %q", pub, kid)
+	}
+
+	k.keys[kid] = &jwtKey{kid: kid, method: method, public: pub}
+	return nil
+}
+
+//LoadKeyRing reads every *.pem file in dir into a key ring, using the file
+//name without extension as the kid. Private keys (PKCS#1 or PKCS#8) can sign,
+//public keys (PKIX) are verification-only. signingKID picks the signing key;
+//it may be empty when the directory holds exactly one private key.
+//If secret is not empty, HS256 tokens signed with it are still accepted.
+func LoadKeyRing(dir, signingKID, secret string) (*KeyRing, error) {
+	k := NewKeyRing()
+	if secret != "" {
+		k.secret = []byte(secret)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var privateKeys []string
+	signers := map[string]crypto.Signer{}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePEMKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key %q: %w", file, err)
+		}
+
+		if signer, ok := key.(crypto.Signer); ok {
+			signers[kid] = signer
+			privateKeys = append(privateKeys, kid)
+			continue
+		}
+		if err := k.AddVerificationKey(kid, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if signingKID == "" {
+		if len(privateKeys) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %q, set the signing key id", len(privateKeys), dir)
+		}
+		signingKID = privateKeys[0]
+	}
+
+	//add the signing key last so it ends up as the active one
+	for kid, signer := range signers {
+		if kid == signingKID {
+			continue
+		}
+		if err := k.AddVerificationKey(kid, signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+	signer, ok := signers[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("no private key found for signing key id %q", signingKID)
+	}
+	if err := k.AddSigningKey(signingKID, signer); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+//parse a PEM encoded private or public key
+func parsePEMKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+//MakeJWT signs an access token for the user with the current signing key,
+//falling back to HS256 when the ring only has a shared secret. The
+//registered claims (sub, iss, aud, iat, exp, jti) are set by the ring.
+func (k *KeyRing) MakeJWT(userID uuid.UUID, claims Claims, expiresIn time.Duration) (string, error) {
+	//fill in the registered claims, every token gets a unique jti
+	now := time.Now().UTC()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:			uuid.NewString(),
+		Issuer:		k.issuer,
+		Audience:	jwt.ClaimStrings{k.audience},
+		IssuedAt:	jwt.NewNumericDate(now),
+		ExpiresAt:	jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:	userID.String(),
+	}
+
+	var tokenString string
+	var err error
+	if k.signing != nil {
+		token := jwt.NewWithClaims(k.signing.method, claims)
+		token.Header["kid"] = k.signing.kid
+		tokenString, err = token.SignedString(k.signing.private)
+	} else if k.secret != nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err = token.SignedString(k.secret)
+	} else {
+		err = fmt.Errorf("no signing key configured")
+	}
+	if err != nil {
+		log.Printf("Error signing token: %v", err)
+		return "", err
+	}
+	return tokenString, nil
+}
+
+//ValidateJWT checks the token signature against the key named by its kid
+//header (or the shared secret for HS256 tokens), its issuer, audience, token
+//version and the denylist, and returns its claims.
+func (k *KeyRing) ValidateJWT(ctx context.Context, tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&Claims{},
+		k.keyFunc,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithIssuer(k.issuer),
+		jwt.WithAudience(k.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(k.leeway),
+	)
+	//check if theres any error or token invalid
+	if err != nil || !token.Valid {
+		log.Printf("Error parsing token claim: %v", err)
+		return nil, fmt.Errorf("invalid token")
+	}
+	//check the claims
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims type")
+	}
+
+	id, err := claims.UserID()
+	if err != nil {
+		return nil, err
+	}
+
+	if k.denylist != nil && (claims.ID == "" || k.denylist.IsRevoked(claims.ID)) {
+		return nil, ErrTokenRevoked
+	}
+
+	if k.tokenVersion != nil {
+		current, err := k.tokenVersion(ctx, id)
+		if err != nil {
+			log.Printf("Error getting token version: %v", err)
+			return nil, fmt.Errorf("invalid token")
+		}
+		if claims.TokenVersion != current {
+			return nil, fmt.Errorf("token has been superseded")
+		}
+	}
+	return claims, nil
+}
+
+//pick the verification key for a token, making sure the token's algorithm
+//matches the key so an RSA public key can never be used as an HMAC secret
+func (k *KeyRing) keyFunc(t *jwt.Token) (any, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if k.secret == nil {
+			return nil, fmt.Errorf("HMAC tokens are not accepted")
+		}
+		return k.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", t.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+//JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	//RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	//Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+//JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//JWKS returns every public verification key in the ring. The shared HS256
+//secret is never published.
+func (k *KeyRing) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+		}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey err: %v", err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey err: %v", err)
+	}
+	return key
+}
+
+func TestKeyRing_RS256(t *testing.T){
+	ring := NewKeyRing()
+	if err := ring.AddSigningKey("rsa-1", newRSAKey(t)); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+
+	userID := uuid.New()
+	tok, err := ring.MakeJWT(userID, Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	claims, err := ring.ValidateJWT(context.Background(), tok)
+	if err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+	if claims.Subject != userID.String() {
+		t.Fatalf("want %v, got %v", userID, claims.Subject)
+	}
+}
+
+func TestKeyRing_EdDSA(t *testing.T){
+	ring := NewKeyRing()
+	if err := ring.AddSigningKey("ed-1", newEd25519Key(t)); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+
+	userID := uuid.New()
+	tok, err := ring.MakeJWT(userID, Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	claims, err := ring.ValidateJWT(context.Background(), tok)
+	if err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+	if claims.Subject != userID.String() {
+		t.Fatalf("want %v, got %v", userID, claims.Subject)
+	}
+}
+
+//tokens signed with the old key keep working after rotating to a new one
+func TestKeyRing_Rotation(t *testing.T){
+	oldKey := newRSAKey(t)
+
+	ring := NewKeyRing()
+	if err := ring.AddSigningKey("old", oldKey); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+	oldTok, err := ring.MakeJWT(uuid.New(), Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	if err := ring.AddSigningKey("new", newEd25519Key(t)); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), oldTok); err != nil {
+		t.Fatalf("expected token signed with retired key to validate: %v", err)
+	}
+
+	//a ring that only knows the new key must reject the old token
+	other := NewKeyRing()
+	if err := other.AddVerificationKey("new", ring.keys["new"].public); err != nil {
+		t.Fatalf("AddVerificationKey err: %v", err)
+	}
+	if _, err := other.ValidateJWT(context.Background(), oldTok); err == nil {
+		t.Fatalf("expected error for token signed with unknown key")
+	}
+}
+
+//an HS256 token must not be accepted by a ring without a shared secret
+func TestKeyRing_RejectsHMACWithoutSecret(t *testing.T){
+	tok, err := MakeJWT(uuid.New(), "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	ring := NewKeyRing()
+	if err := ring.AddSigningKey("rsa-1", newRSAKey(t)); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); err == nil {
+		t.Fatalf("expected error for HS256 token")
+	}
+}
+
+func TestKeyRing_JWKS(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	if err := ring.AddSigningKey("b-ed", newEd25519Key(t)); err != nil {
+		t.Fatalf("AddSigningKey err: %v", err)
+	}
+	if err := ring.AddVerificationKey("a-rsa", newRSAKey(t).Public()); err != nil {
+		t.Fatalf("AddVerificationKey err: %v", err)
+	}
+
+	jwks := ring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("want 2 keys, got %d", len(jwks.Keys))
+	}
+	rsaKey, edKey := jwks.Keys[0], jwks.Keys[1]
+	if rsaKey.Kid != "a-rsa" || rsaKey.Kty != "RSA" || rsaKey.Alg != "RS256" || rsaKey.N == "" || rsaKey.E != "AQAB" {
+		t.Fatalf("unexpected RSA JWK: %+v", rsaKey)
+	}
+	if edKey.Kid != "b-ed" || edKey.Kty != "OKP" || edKey.Crv != "Ed25519" || edKey.Alg != "EdDSA" || edKey.X == "" {
+		t.Fatalf("unexpected Ed25519 JWK: %+v", edKey)
+	}
+}
+
+func TestLoadKeyRing(t *testing.T){
+	dir := t.TempDir()
+
+	writePEM := func(name, blockType string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("WriteFile err: %v", err)
+		}
+	}
+
+	current := newEd25519Key(t)
+	der, err := x509.MarshalPKCS8PrivateKey(current)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey err: %v", err)
+	}
+	writePEM("current.pem", "PRIVATE KEY", der)
+
+	retired := newRSAKey(t)
+	writePEM("retired.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(retired))
+
+	pubDer, err := x509.MarshalPKIXPublicKey(newRSAKey(t).Public())
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey err: %v", err)
+	}
+	writePEM("other-service.pem", "PUBLIC KEY", pubDer)
+
+	//two private keys, so the signing key must be named
+	if _, err := LoadKeyRing(dir, "", ""); err == nil {
+		t.Fatalf("expected error without signing key id")
+	}
+
+	ring, err := LoadKeyRing(dir, "current", "")
+	if err != nil {
+		t.Fatalf("LoadKeyRing err: %v", err)
+	}
+	if ring.signing.kid != "current" {
+		t.Fatalf("want signing key %q, got %q", "current", ring.signing.kid)
+	}
+	if len(ring.JWKS().Keys) != 3 {
+		t.Fatalf("want 3 keys, got %d", len(ring.JWKS().Keys))
+	}
+
+	tok, err := ring.MakeJWT(uuid.New(), Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+}
+
+func TestKeyRing_TokenVersion(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	current := int32(3)
+	ring.SetTokenVersionFunc(func(ctx context.Context, userID uuid.UUID) (int32, error) {
+		return current, nil
+	})
+
+	userID := uuid.New()
+	tok, err := ring.MakeJWT(userID, Claims{TokenVersion: 3}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+
+	//changing the password bumps the version
+	current = 4
+	if _, err := ring.ValidateJWT(context.Background(), tok); err == nil {
+		t.Fatalf("expected error for superseded token")
+	}
+}
+
+func TestKeyRing_CustomClaims(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+
+	userID := uuid.New()
+	tok, err := ring.MakeJWT(userID, Claims{
+		Roles: []string{"admin"},
+		Scopes: []string{ScopeChirpsWrite},
+		ChirpyRed: true,
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	claims, err := ring.ValidateJWT(context.Background(), tok)
+	if err != nil {
+		t.Fatalf("ValidateJWT err: %v", err)
+	}
+	if !claims.HasRole("admin") || claims.HasRole("moderator") {
+		t.Errorf("roles = %v", claims.Roles)
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != ScopeChirpsWrite {
+		t.Errorf("scopes = %v", claims.Scopes)
+	}
+	if !claims.ChirpyRed {
+		t.Errorf("chirpy_red not set")
+	}
+	if claims.Issuer != DefaultIssuer || len(claims.Audience) != 1 || claims.Audience[0] != DefaultAudience {
+		t.Errorf("iss = %q, aud = %v", claims.Issuer, claims.Audience)
+	}
+	if id, err := claims.UserID(); err != nil || id != userID {
+		t.Errorf("UserID() = %v, %v", id, err)
+	}
+
+	other, _ := ring.MakeJWT(userID, Claims{}, time.Minute)
+	otherClaims, _ := ring.ValidateJWT(context.Background(), other)
+	if claims.ID == "" || claims.ID == otherClaims.ID {
+		t.Errorf("jti should be unique, got %q and %q", claims.ID, otherClaims.ID)
+	}
+}
+
+func TestKeyRing_IssuerAndAudience(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	tok, err := ring.MakeJWT(uuid.New(), Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	otherIssuer := NewHMACKeyRing("secret")
+	otherIssuer.SetIssuer("someone-else")
+	if _, err := otherIssuer.ValidateJWT(context.Background(), tok); err == nil {
+		t.Errorf("expected error for wrong issuer")
+	}
+
+	otherAudience := NewHMACKeyRing("secret")
+	otherAudience.SetAudience("another-api")
+	if _, err := otherAudience.ValidateJWT(context.Background(), tok); err == nil {
+		t.Errorf("expected error for wrong audience")
+	}
+}
+
+func TestKeyRing_Leeway(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	//expired 10 seconds ago, within the default 30 second leeway
+	tok, err := ring.MakeJWT(uuid.New(), Claims{}, -10*time.Second)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+	if _, err := ring.ValidateJWT(context.Background(), tok); err != nil {
+		t.Errorf("token within leeway rejected: %v", err)
+	}
+
+	ring.SetLeeway(0)
+	if _, err := ring.ValidateJWT(context.Background(), tok); err == nil {
+		t.Errorf("expected error for expired token without leeway")
+	}
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"time"
+)
+
+//LockoutPolicy decides how long logins are blocked after repeated failures.
+//Once Threshold consecutive failures are reached every further failure
+//doubles the lockout, starting at BaseDelay and capped at MaxDelay.
+type LockoutPolicy struct {
+	Threshold	int
+	BaseDelay	time.Duration
+	MaxDelay	time.Duration
+}
+
+//Delay returns how long to lock out after the given number of consecutive
+//failures, or 0 when no lockout is needed yet.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
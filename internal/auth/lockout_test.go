@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T){
+	policy := LockoutPolicy{
+		Threshold: 5,
+		BaseDelay: time.Minute,
+		MaxDelay: time.Hour,
+	}
+
+	tests := []struct {
+		failures	int
+		want		time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 4, want: 0},
+		{failures: 5, want: time.Minute},
+		{failures: 6, want: 2 * time.Minute},
+		{failures: 7, want: 4 * time.Minute},
+		{failures: 10, want: 32 * time.Minute},
+		//64 minutes is over the cap
+		{failures: 11, want: time.Hour},
+		{failures: 1000, want: time.Hour},
+	}
+
+	for _, tc := range tests {
+		if got := policy.Delay(tc.failures); got != tc.want {
+			t.Errorf("Delay(%d): want %v, got %v", tc.failures, tc.want, got)
+		}
+	}
+}
+
+func TestLockoutPolicyDisabled(t *testing.T){
+	policy := LockoutPolicy{}
+	if got := policy.Delay(100); got != 0 {
+		t.Fatalf("want no delay for a disabled policy, got %v", got)
+	}
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+//Principal is the authenticated caller of a request
+type Principal struct {
+	UserID	uuid.UUID
+	//Claims of the access token, nil for personal access tokens
+	Claims	*Claims
+	//Scopes granted to the credential, from the token claims or the
+	//personal access token
+	Scopes	[]string
+	//set when the caller used a personal access token
+	PersonalAccessToken	bool
+}
+
+//HasScope reports whether the caller's credential grants scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//HasRole reports whether the caller logged in as a user with role. Personal
+//access tokens have no roles.
+func (p *Principal) HasRole(role string) bool {
+	return p.Claims != nil && p.Claims.HasRole(role)
+}
+
+type principalKey struct{}
+
+//ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+//PrincipalFromContext returns the caller stored by RequireAuth, OptionalAuth
+//or their variants, and false for anonymous requests
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+//MustPrincipal returns the caller of a request that went through RequireAuth
+//or RequireScope, which have already checked the token (and scope) and
+//rejected anonymous requests. It panics on routes not wrapped in either.
+func MustPrincipal(r *http.Request) *Principal {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		panic("auth: MustPrincipal called on a request without a principal")
+	}
+	return p
+}
+
+//PersonalAccessTokenFunc looks up an active personal access token by its
+//HashToken digest and returns its owner and scopes
+type PersonalAccessTokenFunc func(ctx context.Context, tokenHash string) (uuid.UUID, []string, error)
+
+//Authenticator is HTTP middleware that checks bearer access tokens
+type Authenticator struct {
+	keys	*KeyRing
+	pats	PersonalAccessTokenFunc
+	//Realm sent in WWW-Authenticate challenges
+	Realm	string
+}
+
+func NewAuthenticator(keys *KeyRing) *Authenticator {
+	return &Authenticator{keys: keys, Realm: "chirpy"}
+}
+
+//SetPersonalAccessTokenFunc enables personal access tokens. Without it they
+//are rejected like any other invalid token.
+func (a *Authenticator) SetPersonalAccessTokenFunc(fn PersonalAccessTokenFunc) {
+	a.pats = fn
+}
+
+//RequireAuth only lets requests with a valid access token through. Personal
+//access tokens are refused, so account management needs a real login.
+func (a *Authenticator) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if r.Header.Get("Authorization") == "" {
+			a.challenge(w, 401, "", "Authentication required", "")
+			return
+		}
+		a.authenticate(w, r, next, false)
+	})
+}
+
+//RequireScope lets access tokens and personal access tokens through if
+//they were granted scope
+func (a *Authenticator) RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if r.Header.Get("Authorization") == "" {
+			a.challenge(w, 401, "", "Authentication required", "")
+			return
+		}
+		a.authenticate(w, r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+			p, _ := PrincipalFromContext(r.Context())
+			if !p.HasScope(scope) {
+				a.challenge(w, 403, "insufficient_scope", "Token is missing the "+scope+" scope", scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}), true)
+	})
+}
+
+//RequireRole only lets logged in users with role through. Personal access
+//tokens never carry roles, so they are refused like in RequireAuth.
+func (a *Authenticator) RequireRole(role string, next http.Handler) http.Handler {
+	return a.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		p, _ := PrincipalFromContext(r.Context())
+		if !p.HasRole(role) {
+			a.challenge(w, 403, "insufficient_scope", "Requires the "+role+" role", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+//OptionalAuth lets anonymous requests through, but a request that does send
+//a token still has to send a valid one
+func (a *Authenticator) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		a.authenticate(w, r, next, true)
+	})
+}
+
+//OptionalScope lets anonymous requests through like OptionalAuth, but a
+//request that does send a token needs one that was granted scope
+func (a *Authenticator) OptionalScope(scope string, next http.Handler) http.Handler {
+	return a.OptionalAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if p, ok := PrincipalFromContext(r.Context()); ok && !p.HasScope(scope) {
+			a.challenge(w, 403, "insufficient_scope", "Token is missing the "+scope+" scope", scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request, next http.Handler, allowPAT bool) {
+	token, err := GetBearerToken(r.Header)
+	//other schemes just get the plain challenge, as if no credentials were sent
+	if errors.Is(err, ErrWrongAuthScheme) {
+		a.challenge(w, 401, "", "Bearer token required", "")
+		return
+	}
+	if err != nil {
+		a.challenge(w, 401, "invalid_request", "Malformed Authorization header", "")
+		return
+	}
+
+	var principal *Principal
+	if IsPersonalAccessToken(token) {
+		principal, err = a.personalAccessToken(r.Context(), token)
+		if err != nil {
+			log.Printf("Error validating personal access token: %v", err)
+			a.challenge(w, 401, "invalid_token", "Invalid or expired token", "")
+			return
+		}
+		if !allowPAT {
+			a.challenge(w, 403, "insufficient_scope", "Personal access tokens can't be used here", "")
+			return
+		}
+	} else {
+		claims, err := a.keys.ValidateJWT(r.Context(), token)
+		if err != nil {
+			log.Printf("Error validating user token: %v", err)
+			a.challenge(w, 401, "invalid_token", "Invalid or expired token", "")
+			return
+		}
+		userID, err := claims.UserID()
+		if err != nil {
+			a.challenge(w, 401, "invalid_token", "Invalid or expired token", "")
+			return
+		}
+		principal = &Principal{
+			UserID: userID,
+			Claims: claims,
+			Scopes: claims.Scopes,
+		}
+	}
+
+	next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+}
+
+func (a *Authenticator) personalAccessToken(ctx context.Context, token string) (*Principal, error) {
+	if a.pats == nil {
+		return nil, errors.New("personal access tokens are not enabled")
+	}
+	userID, scopes, err := a.pats(ctx, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		UserID: userID,
+		Scopes: scopes,
+		PersonalAccessToken: true,
+	}, nil
+}
+
+//respond with a bearer challenge as described in RFC 6750. errCode is empty
+//when the request had no bearer credentials at all, scope is the scope the
+//request was missing.
+func (a *Authenticator) challenge(w http.ResponseWriter, status int, errCode, msg, scope string) {
+	value := fmt.Sprintf("Bearer realm=%q", a.Realm)
+	if errCode != "" {
+		value += fmt.Sprintf(", error=%q, error_description=%q", errCode, msg)
+	}
+	if scope != "" {
+		value += fmt.Sprintf(", scope=%q", scope)
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: msg})
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRequireAuth(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	authn := NewAuthenticator(ring)
+
+	userID := uuid.New()
+	valid, err := ring.MakeJWT(userID, Claims{}, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+	expired, err := ring.MakeJWT(userID, Claims{}, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT err: %v", err)
+	}
+
+	var got *Principal
+	handler := authn.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		got, _ = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name		string
+		header		string
+		wantCode	int
+		wantError	string
+	}{
+		{"valid token", "Bearer " + valid, http.StatusNoContent, ""},
+		{"lowercase scheme", "bearer " + valid, http.StatusNoContent, ""},
+		{"no header", "", http.StatusUnauthorized, ""},
+		{"missing token", "Bearer", http.StatusUnauthorized, "invalid_request"},
+		{"wrong scheme", "ApiKey " + valid, http.StatusUnauthorized, ""},
+		{"extra fields", "Bearer " + valid + " x", http.StatusUnauthorized, "invalid_request"},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized, "invalid_token"},
+		{"garbage token", "Bearer abc.def.ghi", http.StatusUnauthorized, "invalid_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			got = nil
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusNoContent {
+				if got == nil || got.UserID != userID {
+					t.Fatalf("principal = %+v, want user %v", got, userID)
+				}
+				return
+			}
+
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if !strings.HasPrefix(challenge, `Bearer realm="chirpy"`) {
+				t.Errorf("WWW-Authenticate = %q", challenge)
+			}
+			if tt.wantError == "" && strings.Contains(challenge, "error=") {
+				t.Errorf("challenge for a request without credentials has an error: %q", challenge)
+			}
+			if tt.wantError != "" && !strings.Contains(challenge, `error="`+tt.wantError+`"`) {
+				t.Errorf("WWW-Authenticate = %q, want error %q", challenge, tt.wantError)
+			}
+			if got != nil {
+				t.Errorf("handler ran for a rejected request")
+			}
+		})
+	}
+}
+
+func TestOptionalAuth(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	authn := NewAuthenticator(ring)
+
+	called := false
+	var hasPrincipal bool
+	handler := authn.OptionalAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		called = true
+		_, hasPrincipal = PrincipalFromContext(r.Context())
+	}))
+
+	//anonymous requests get through without a principal
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !called || hasPrincipal {
+		t.Fatalf("anonymous request: called = %v, principal = %v", called, hasPrincipal)
+	}
+
+	//a bad token is still rejected
+	called = false
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer nope")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if called || rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bad token: called = %v, status = %d", called, rec.Code)
+	}
+
+	//a good one adds the principal
+	tok, _ := ring.MakeJWT(uuid.New(), Claims{}, time.Minute)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !called || !hasPrincipal {
+		t.Fatalf("good token: called = %v, principal = %v", called, hasPrincipal)
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	authn := NewAuthenticator(ring)
+
+	userID := uuid.New()
+	pat, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken err: %v", err)
+	}
+	if !IsPersonalAccessToken(pat) {
+		t.Fatalf("token %q is missing the prefix", pat)
+	}
+	authn.SetPersonalAccessTokenFunc(func(ctx context.Context, tokenHash string) (uuid.UUID, []string, error){
+		if tokenHash != HashToken(pat) {
+			return uuid.Nil, nil, errors.New("not found")
+		}
+		return userID, []string{ScopeChirpsWrite}, nil
+	})
+	jwt, _ := ring.MakeJWT(userID, Claims{Scopes: AllScopes()}, time.Minute)
+	readOnly, _ := ring.MakeJWT(userID, Claims{Scopes: []string{ScopeChirpsRead}}, time.Minute)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name		string
+		handler		http.Handler
+		token		string
+		wantCode	int
+	}{
+		{"pat with scope", authn.RequireScope(ScopeChirpsWrite, ok), pat, http.StatusNoContent},
+		{"pat without scope", authn.RequireScope(ScopeChirpsRead, ok), pat, http.StatusForbidden},
+		{"unknown pat", authn.RequireScope(ScopeChirpsWrite, ok), PersonalAccessTokenPrefix + "nope", http.StatusUnauthorized},
+		{"pat on login-only route", authn.RequireAuth(ok), pat, http.StatusForbidden},
+		{"jwt with scope", authn.RequireScope(ScopeChirpsWrite, ok), jwt, http.StatusNoContent},
+		{"jwt without scope", authn.RequireScope(ScopeChirpsWrite, ok), readOnly, http.StatusForbidden},
+		{"pat with optional auth", authn.OptionalAuth(ok), pat, http.StatusNoContent},
+		{"pat with optional scope", authn.OptionalScope(ScopeChirpsWrite, ok), pat, http.StatusNoContent},
+		{"pat without optional scope", authn.OptionalScope(ScopeChirpsRead, ok), pat, http.StatusForbidden},
+		{"jwt with optional scope", authn.OptionalScope(ScopeChirpsRead, ok), readOnly, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusForbidden && !strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+				t.Errorf("WWW-Authenticate = %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestValidateScopes(t *testing.T){
+	if err := ValidateScopes([]string{ScopeChirpsRead, ScopeChirpsWrite}); err != nil {
+		t.Errorf("known scopes rejected: %v", err)
+	}
+	if err := ValidateScopes(nil); err == nil {
+		t.Errorf("empty scopes accepted")
+	}
+	if err := ValidateScopes([]string{"admin"}); err == nil {
+		t.Errorf("unknown scope accepted")
+	}
+}
+
+func TestRequireRole(t *testing.T){
+	ring := NewHMACKeyRing("secret")
+	authn := NewAuthenticator(ring)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		w.WriteHeader(http.StatusNoContent)
+	})
+	userID := uuid.New()
+	admin, _ := ring.MakeJWT(userID, Claims{Roles: RolesFor(RoleAdmin)}, time.Minute)
+	moderator, _ := ring.MakeJWT(userID, Claims{Roles: RolesFor(RoleModerator)}, time.Minute)
+	user, _ := ring.MakeJWT(userID, Claims{Roles: RolesFor(RoleUser)}, time.Minute)
+
+	tests := []struct {
+		name		string
+		role		string
+		token		string
+		wantCode	int
+	}{
+		{"admin on admin route", RoleAdmin, admin, http.StatusNoContent},
+		{"admin on moderator route", RoleModerator, admin, http.StatusNoContent},
+		{"moderator on moderator route", RoleModerator, moderator, http.StatusNoContent},
+		{"moderator on admin route", RoleAdmin, moderator, http.StatusForbidden},
+		{"user on admin route", RoleAdmin, user, http.StatusForbidden},
+		{"no token", RoleAdmin, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			req := httptest.NewRequest("GET", "/admin/metrics", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			authn.RequireRole(tt.role, ok).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMustPrincipal(t *testing.T){
+	want := &Principal{UserID: uuid.New()}
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(ContextWithPrincipal(req.Context(), want))
+	if got := MustPrincipal(req); got != want {
+		t.Errorf("MustPrincipal: want %v, got %v", want, got)
+	}
+
+	defer func(){
+		if recover() == nil {
+			t.Errorf("MustPrincipal without a principal: want panic")
+		}
+	}()
+	MustPrincipal(httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+//bcrypt ignores everything after the first 72 bytes of a password
+const MaxBcryptPasswordBytes = 72
+
+//PasswordPolicy describes what a new password has to look like
+type PasswordPolicy struct {
+	//minimum length in characters
+	MinLength	int
+	//maximum length in bytes, never more than MaxBcryptPasswordBytes
+	MaxLength	int
+	//optional list of known breached passwords
+	Breached	*BreachedPasswords
+}
+
+//PasswordPolicyError lists every rule a password failed
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, "; ")
+}
+
+//Validate checks the password against every rule of the policy and returns
+//a *PasswordPolicyError listing all of the failed ones
+func (p PasswordPolicy) Validate(password, email string) error {
+	var violations []string
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > MaxBcryptPasswordBytes {
+		maxLength = MaxBcryptPasswordBytes
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > maxLength {
+		violations = append(violations, fmt.Sprintf("password must be at most %d bytes long", maxLength))
+	}
+	if email != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		violations = append(violations, "password must not be the same as the email address")
+	}
+	if p.Breached != nil && password != "" && p.Breached.Contains(password) {
+		violations = append(violations, "password has appeared in a data breach, choose a different one")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+//BreachedPasswords is a set of SHA-1 password hashes indexed by their first
+//five hex characters, the same k-anonymity split used by Have I Been Pwned
+//range files, so lookups only ever touch one small bucket
+type BreachedPasswords struct {
+	buckets map[string]map[string]struct{}
+}
+
+//LoadBreachedPasswords reads a file with one upper or lower case hex SHA-1
+//hash per line, optionally followed by ":count" as in the Have I Been Pwned
+//downloads. Empty lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedPasswords{buckets: map[string]map[string]struct{}{}}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%v:%d: not a SHA-1 hash", path, lineNo)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%v:%d: not a SHA-1 hash", path, lineNo)
+		}
+		b.add(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BreachedPasswords) add(hash string) {
+	prefix, suffix := hash[:5], hash[5:]
+	if b.buckets[prefix] == nil {
+		b.buckets[prefix] = map[string]struct{}{}
+	}
+	b.buckets[prefix][suffix] = struct{}{}
+}
+
+//Contains reports whether the password is in the breached list
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, ok := b.buckets[hash[:5]][hash[5:]]
+	return ok
+}
+
+//Len returns the number of hashes in the list
+func (b *BreachedPasswords) Len() int {
+	n := 0
+	for _, bucket := range b.buckets {
+		n += len(bucket)
+	}
+	return n
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeBreachedFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatalf("WriteFile err: %v", err)
+	}
+	return path
+}
+
+func TestLoadBreachedPasswords(t *testing.T){
+	path := writeBreachedFile(t,
+		"# common passwords",
+		//SHA-1 of "password"
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493",
+		//SHA-1 of "letmein", lower case and without a count
+		"b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3",
+		"",
+	)
+
+	b, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords err: %v", err)
+	}
+	if b.Len() != 2 {
+		t.Fatalf("want 2 hashes, got %d", b.Len())
+	}
+	for _, pw := range []string{"password", "letmein"} {
+		if !b.Contains(pw) {
+			t.Errorf("expected %q to be breached", pw)
+		}
+	}
+	if b.Contains("correct horse battery staple") {
+		t.Errorf("expected unlisted password not to be breached")
+	}
+}
+
+func TestLoadBreachedPasswords_Invalid(t *testing.T){
+	path := writeBreachedFile(t, "not-a-hash")
+	if _, err := LoadBreachedPasswords(path); err == nil {
+		t.Fatalf("expected error for invalid line")
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T){
+	breached, err := LoadBreachedPasswords(writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"))
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords err: %v", err)
+	}
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 72, Breached: breached}
+
+	tests := []struct {
+		name		string
+		password	string
+		email		string
+		want		[]string
+	}{
+		{
+			name: "valid",
+			password: "a perfectly fine passphrase",
+			email: "user@example.com",
+		},
+		{
+			name: "empty",
+			password: "",
+			want: []string{"password must be at least 8 characters long"},
+		},
+		{
+			name: "too long for bcrypt",
+			password: strings.Repeat("a", 73),
+			want: []string{"password must be at most 72 bytes long"},
+		},
+		{
+			name: "same as email",
+			password: "User@Example.com",
+			email: "user@example.com",
+			want: []string{"password must not be the same as the email address"},
+		},
+		{
+			name: "breached",
+			password: "password",
+			want: []string{"password has appeared in a data breach, choose a different one"},
+		},
+		{
+			name: "every rule reported",
+			password: "a@b.co",
+			email: "a@b.co",
+			want: []string{
+				"password must be at least 8 characters long",
+				"password must not be the same as the email address",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T){
+			err := policy.Validate(tc.password, tc.email)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("expected *PasswordPolicyError, got %v", err)
+			}
+			if !reflect.DeepEqual(policyErr.Violations, tc.want) {
+				t.Fatalf("want %v, got %v", tc.want, policyErr.Violations)
+			}
+		})
+	}
+}
+
+//the maximum length can never exceed what bcrypt actually uses
+func TestPasswordPolicyMaxLengthCapped(t *testing.T){
+	policy := PasswordPolicy{MaxLength: 1000}
+	if err := policy.Validate(strings.Repeat("a", 73), ""); err == nil {
+		t.Fatalf("expected error for password longer than 72 bytes")
+	}
+}
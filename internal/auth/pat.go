@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//PersonalAccessTokenPrefix starts every personal access token, so they can be
+//told apart from JWTs and spotted by secret scanners
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+//scopes of access tokens and personal access tokens
+const (
+	ScopeChirpsRead = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+)
+
+var knownScopes = map[string]bool{
+	ScopeChirpsRead: true,
+	ScopeChirpsWrite: true,
+}
+
+//AllScopes returns every scope, which is what access tokens from a login get
+func AllScopes() []string {
+	scopes := make([]string, 0, len(knownScopes))
+	for scope := range knownScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+//MakePersonalAccessToken returns a new random personal access token
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+//IsPersonalAccessToken reports whether token looks like a personal access token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+//ValidateScopes checks that scopes is a non-empty list of known scopes
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
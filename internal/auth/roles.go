@@ -0,0 +1,30 @@
+package auth
+
+//user roles, each one can do everything the roles below it can
+const (
+	RoleUser = "user"
+	RoleModerator = "moderator"
+	RoleAdmin = "admin"
+)
+
+//ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+//RolesFor returns role together with the roles it includes, which is what
+//goes into the roles claim so a plain HasRole check respects the hierarchy
+func RolesFor(role string) []string {
+	switch role {
+	case RoleAdmin:
+		return []string{RoleAdmin, RoleModerator, RoleUser}
+	case RoleModerator:
+		return []string{RoleModerator, RoleUser}
+	default:
+		return []string{RoleUser}
+	}
+}
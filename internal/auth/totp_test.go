@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits
+func TestTOTPCode_RFC6238(t *testing.T){
+	//base32 of the ASCII secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		unix	int64
+		want	string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tc := range tests {
+		got, err := TOTPCode(secret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode err: %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("TOTPCode at %d: want %v, got %v", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T){
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret err: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("TOTPCode err: %v", err)
+	}
+	step, ok := ValidateTOTP(secret, code, now)
+	if !ok || step != now.Unix()/30 {
+		t.Fatalf("expected code to validate at step %d, got %d %v", now.Unix()/30, step, ok)
+	}
+
+	//one step of clock drift is fine
+	if _, ok := ValidateTOTP(secret, code, now.Add(30*time.Second)); !ok {
+		t.Fatalf("expected code from the previous step to validate")
+	}
+	//two steps is not
+	if _, ok := ValidateTOTP(secret, code, now.Add(90*time.Second)); ok {
+		t.Fatalf("expected code from two steps ago to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Fatalf("expected short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T){
+	uri := TOTPURI("Chirpy", "user@example.com", "ABCDEF")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") {
+		t.Fatalf("unexpected URI: %v", uri)
+	}
+	for _, want := range []string{"secret=ABCDEF", "issuer=Chirpy", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI %v missing %v", uri, want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T){
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes err: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("want 10 codes, got %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		seen[code] = true
+
+		if NormalizeRecoveryCode(strings.ToUpper(code)) != strings.ReplaceAll(code, "-", "") {
+			t.Fatalf("normalizing %q failed", code)
+		}
+	}
+}
@@ -7,32 +7,62 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirps = `-- name: CreateChirps :one
-INSERT INTO chirps (body, user_id)
+INSERT INTO chirps (body, user_id, parent_id, root_id)
 VALUES (
     $1,
-    $2
-) RETURNING id, created_at, updated_at, body, user_id
+    $2,
+    $3,
+    $4
+) RETURNING id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at
 `
 
 type CreateChirpsParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body     string
+	UserID   uuid.UUID
+	ParentID uuid.NullUUID
+	RootID   uuid.NullUUID
 }
 
-func (q *Queries) CreateChirps(ctx context.Context, arg CreateChirpsParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirps, arg.Body, arg.UserID)
-	var i Chirp
+type CreateChirpsRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) CreateChirps(ctx context.Context, arg CreateChirpsParams) (CreateChirpsRow, error) {
+	row := q.db.QueryRowContext(ctx, createChirps,
+		arg.Body,
+		arg.UserID,
+		arg.ParentID,
+		arg.RootID,
+	)
+	var i CreateChirpsRow
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditCount,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -48,45 +78,226 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
 FROM chirps
-WHERE id = $1
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1 AND users.deleted_at IS NULL AND chirps.deleted_at IS NULL
 `
 
-func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+type GetChirpRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (GetChirpRow, error) {
 	row := q.db.QueryRowContext(ctx, getChirp, id)
-	var i Chirp
+	var i GetChirpRow
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditCount,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.parent_id, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = $1
+  UNION ALL
+    SELECT chirps.id, chirps.parent_id, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.parent_id
+)
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted
+FROM ancestors
+JOIN chirps ON chirps.id = ancestors.id
+JOIN users ON users.id = chirps.user_id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC
+`
+
+type GetChirpAncestorsRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	Deleted    bool
+}
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, chirpID uuid.UUID) ([]GetChirpAncestorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpAncestorsRow
+	for rows.Next() {
+		var i GetChirpAncestorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditCount,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.Deleted,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at
 FROM chirps
-ORDER BY created_at ASC
+WHERE id = $1
+FOR UPDATE
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpForUpdateRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (GetChirpForUpdateRow, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i GetChirpForUpdateRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditCount,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getChirpReplies = `-- name: GetChirpReplies :many
+WITH RECURSIVE replies AS (
+    SELECT chirps.id, 1 AS depth,
+        ARRAY[to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text] AS path
+    FROM chirps
+    WHERE chirps.parent_id = $1
+  UNION ALL
+    SELECT chirps.id, replies.depth + 1,
+        replies.path || (to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text)
+    FROM chirps
+    JOIN replies ON chirps.parent_id = replies.id
+    WHERE replies.depth < $2::integer
+)
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted,
+    replies.depth::integer AS depth,
+    replies.path::text[] AS path
+FROM replies
+JOIN chirps ON chirps.id = replies.id
+JOIN users ON users.id = chirps.user_id
+WHERE replies.path > $3::text[]
+ORDER BY replies.path
+LIMIT $4
+`
+
+type GetChirpRepliesParams struct {
+	ChirpID   uuid.UUID
+	MaxDepth  int32
+	AfterPath []string
+	PageSize  int32
+}
+
+type GetChirpRepliesRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	Deleted    bool
+	Depth      int32
+	Path       []string
+}
+
+func (q *Queries) GetChirpReplies(ctx context.Context, arg GetChirpRepliesParams) ([]GetChirpRepliesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpReplies,
+		arg.ChirpID,
+		arg.MaxDepth,
+		pq.Array(arg.AfterPath),
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []GetChirpRepliesRow
 	for rows.Next() {
-		var i Chirp
+		var i GetChirpRepliesRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditCount,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.Deleted,
+			&i.Depth,
+			pq.Array(&i.Path),
 		); err != nil {
 			return nil, err
 		}
@@ -101,28 +312,207 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id
+const getUserChirpIDs = `-- name: GetUserChirpIDs :many
+SELECT id
 FROM chirps
 WHERE user_id = $1
-ORDER BY created_at ASC
+ORDER BY created_at, id
+`
+
+func (q *Queries) GetUserChirpIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, getUserChirpIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getThreadChirp = `-- name: GetThreadChirp :one
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+`
+
+type GetThreadChirpRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	Deleted    bool
+}
+
+func (q *Queries) GetThreadChirp(ctx context.Context, id uuid.UUID) (GetThreadChirpRow, error) {
+	row := q.db.QueryRowContext(ctx, getThreadChirp, id)
+	var i GetThreadChirpRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditCount,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.Deleted,
+	)
+	return i, err
+}
+
+const listChirps = `-- name: ListChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND ($1::uuid IS NULL OR chirps.user_id = $1::uuid)
+    AND ($2::timestamp IS NULL
+        OR (chirps.created_at, chirps.id) > ($2::timestamp, $3::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $4
+`
+
+type ListChirpsParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
+}
+
+type ListChirpsRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]ListChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpsRow
+	for rows.Next() {
+		var i ListChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditCount,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND ($1::uuid IS NULL OR chirps.user_id = $1::uuid)
+    AND ($2::timestamp IS NULL
+        OR (chirps.created_at, chirps.id) < ($2::timestamp, $3::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
+}
+
+type ListChirpsDescRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]ListChirpsDescRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []ListChirpsDescRow
 	for rows.Next() {
-		var i Chirp
+		var i ListChirpsDescRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditCount,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -136,3 +526,145 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    ts_rank_cd(chirps.search_vector, to_tsquery('english', $1))::real AS rank,
+    ts_headline('english', translate(chirps.body, chr(2) || chr(3), ''), to_tsquery('english', $1),
+        'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MinWords=10, MaxWords=30, MaxFragments=2')::text AS snippet
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND chirps.search_vector @@ to_tsquery('english', $1)
+    AND ($2::uuid IS NULL OR chirps.user_id = $2::uuid)
+ORDER BY
+    CASE WHEN $3::boolean
+        THEN ts_rank_cd(chirps.search_vector, to_tsquery('english', $1))
+    END DESC,
+    chirps.created_at DESC,
+    chirps.id DESC
+LIMIT $4
+`
+
+type SearchChirpsParams struct {
+	Query       string
+	AuthorID    uuid.NullUUID
+	ByRelevance bool
+	PageSize    int32
+}
+
+type SearchChirpsRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	Rank       float32
+	Snippet    string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.ByRelevance,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.EditCount,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET
+    body = '',
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET
+    body = $2,
+    updated_at = now(),
+    edit_count = edit_count + 1
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+type UpdateChirpBodyRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	EditCount  int32
+	ParentID   uuid.NullUUID
+	RootID     uuid.NullUUID
+	ReplyCount int32
+	DeletedAt  sql.NullTime
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (UpdateChirpBodyRow, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i UpdateChirpBodyRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditCount,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+	)
+	return i, err
+}
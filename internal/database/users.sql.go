@@ -7,17 +7,32 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :exec
+UPDATE users
+SET
+    deleted_at = NULL,
+    updated_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users(hashed_password, email)
 VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, email_verified_at, role, deleted_at
 `
 
 type CreateUserParams struct {
@@ -35,10 +50,133 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, email_verified_at, role, deleted_at
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getUserTokenVersion = `-- name: GetUserTokenVersion :one
+SELECT token_version
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const getUsersToPurge = `-- name: GetUsersToPurge :many
+SELECT id
+FROM users
+WHERE deleted_at < $1::timestamp
+`
+
+func (q *Queries) GetUsersToPurge(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersToPurge, deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const incrementTokenVersion = `-- name: IncrementTokenVersion :exec
+UPDATE users
+SET
+    token_version = token_version + 1
+WHERE id = $1
+`
+
+func (q *Queries) IncrementTokenVersion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, incrementTokenVersion, id)
+	return err
+}
+
+const lockUserToPurge = `-- name: LockUserToPurge :one
+SELECT id
+FROM users
+WHERE id = $1 AND deleted_at < $2::timestamp
+FOR UPDATE
+`
+
+type LockUserToPurgeParams struct {
+	ID            uuid.UUID
+	DeletedBefore time.Time
+}
+
+func (q *Queries) LockUserToPurge(ctx context.Context, arg LockUserToPurgeParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, lockUserToPurge, arg.ID, arg.DeletedBefore)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :exec
+UPDATE users
+SET
+    email_verified_at = now()
+WHERE id = $1 AND email_verified_at IS NULL
+`
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markEmailVerified, id)
+	return err
+}
+
 const resetUser = `-- name: ResetUser :exec
 TRUNCATE users CASCADE
 `
@@ -48,11 +186,47 @@ func (q *Queries) ResetUser(ctx context.Context) error {
 	return err
 }
 
+const setUserRole = `-- name: SetUserRole :exec
+UPDATE users
+SET
+    role = $1,
+    token_version = token_version + 1,
+    updated_at = now()
+WHERE id = $2
+`
+
+type SetUserRoleParams struct {
+	Role string
+	ID   uuid.UUID
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) error {
+	_, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.ID)
+	return err
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users
+SET
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING deleted_at
+`
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, id uuid.UUID) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteUser, id)
+	var deleted_at sql.NullTime
+	err := row.Scan(&deleted_at)
+	return deleted_at, err
+}
+
 const updateUser = `-- name: UpdateUser :exec
 UPDATE users
 SET
     hashed_password = $1,
-    email = $2
+    email = $2,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
 WHERE id = $3
 `
 
@@ -67,6 +241,24 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 	return err
 }
 
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    hashed_password = $1,
+    updated_at = now()
+WHERE id = $2
+`
+
+type UpdateUserPasswordParams struct {
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.HashedPassword, arg.ID)
+	return err
+}
+
 const upgradeUser = `-- name: UpgradeUser :exec
 UPDATE users
 SET
@@ -80,7 +272,7 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) error {
 }
 
 const userLogin = `-- name: UserLogin :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, email_verified_at, role, deleted_at
 FROM users
 WHERE email = $1
 `
@@ -95,6 +287,10 @@ func (q *Queries) UserLogin(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Message is a plain text email
+type Message struct {
+	To		string
+	Subject	string
+	Body	string
+}
+
+//Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+//LogMailer is a Mailer for development. It logs every message, and also
+//writes it to a file in Dir when Dir is set.
+type LogMailer struct {
+	Dir string
+}
+
+func (m LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+	log.Printf("Sending email to %v: %v\n%v", msg.To, msg.Subject, msg.Body)
+	if m.Dir == "" {
+		return nil
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), formatMessage("chirpy@localhost", msg), 0600)
+}
+
+//SMTPMailer sends emails through an SMTP server. Username and Password are
+//optional; net/smtp only sends them over TLS or to localhost.
+type SMTPMailer struct {
+	Addr		string
+	Username	string
+	Password	string
+	From		string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg)); err != nil {
+		return fmt.Errorf("sending email to %v: %w", msg.To, err)
+	}
+	return nil
+}
+
+//refuse header values that would let a caller inject extra headers
+func checkHeaders(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+	return nil
+}
+
+//build the RFC 5322 message with CRLF line endings
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+//keep only characters that are safe in a file name
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '@' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+//a minimal SMTP server that accepts a single message
+type fakeSMTP struct {
+	addr	string
+	from	string
+	rcpt	string
+	data	chan string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen err: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &fakeSMTP{addr: ln.Addr().String(), data: make(chan string, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var body strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					srv.data <- body.String()
+					reply("250 OK")
+					continue
+				}
+				body.WriteString(line)
+				continue
+			}
+
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				srv.from = strings.TrimSpace(line[len("MAIL FROM:"):])
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				srv.rcpt = strings.TrimSpace(line[len("RCPT TO:"):])
+				reply("250 OK")
+			case cmd == "DATA":
+				inData = true
+				reply("354 End data with <CR><LF>.<CR><LF>")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return srv
+}
+
+func TestSMTPMailer(t *testing.T){
+	srv := startFakeSMTP(t)
+
+	m := SMTPMailer{Addr: srv.addr, From: "noreply@chirpy.test"}
+	err := m.Send(context.Background(), Message{
+		To: "user@example.com",
+		Subject: "Verify your email",
+		Body: "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+
+	data := <-srv.data
+	if srv.from != "<noreply@chirpy.test>" {
+		t.Fatalf("unexpected MAIL FROM: %v", srv.from)
+	}
+	if srv.rcpt != "<user@example.com>" {
+		t.Fatalf("unexpected RCPT TO: %v", srv.rcpt)
+	}
+	for _, want := range []string{"Subject: Verify your email\r\n", "To: user@example.com\r\n", "line one\r\nline two\r\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message missing %q:\n%v", want, data)
+		}
+	}
+}
+
+func TestLogMailerWritesFile(t *testing.T){
+	dir := t.TempDir()
+
+	m := LogMailer{Dir: dir}
+	if err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "Hi", Body: "hello"}); err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir err: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("want 1 file, got %d", len(files))
+	}
+	if !strings.HasSuffix(files[0].Name(), "user@example.com.eml") {
+		t.Fatalf("unexpected file name %v", files[0].Name())
+	}
+}
+
+func TestMailerRejectsHeaderInjection(t *testing.T){
+	m := LogMailer{}
+	err := m.Send(context.Background(), Message{To: "user@example.com\r\nBcc: evil@example.com", Subject: "Hi"})
+	if err == nil {
+		t.Fatalf("expected error for newline in header")
+	}
+}
@@ -0,0 +1,324 @@
+//Package oidc logs users in with an external OpenID Connect provider using
+//the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+//Config is the client registration at the provider
+type Config struct {
+	//Issuer URL, the discovery document is read from
+	//<Issuer>/.well-known/openid-configuration
+	Issuer			string
+	ClientID		string
+	ClientSecret	string
+	RedirectURL		string
+	//extra scopes besides openid, e.g. email and profile
+	Scopes			[]string
+	//HTTPClient is used for every request to the provider, nil means
+	//http.DefaultClient
+	HTTPClient		*http.Client
+}
+
+//the parts of the discovery document we need
+type discovery struct {
+	Issuer					string `json:"issuer"`
+	AuthorizationEndpoint	string `json:"authorization_endpoint"`
+	TokenEndpoint			string `json:"token_endpoint"`
+	JWKSURI					string `json:"jwks_uri"`
+}
+
+//Provider is a discovered OpenID Connect provider
+type Provider struct {
+	config		Config
+	endpoints	discovery
+	client		*http.Client
+
+	//signing keys by kid, refetched when a token names an unknown kid
+	mu			sync.Mutex
+	keys		map[string]any
+	keysFetched	time.Time
+}
+
+//Token is the response of the token endpoint
+type Token struct {
+	AccessToken	string `json:"access_token"`
+	TokenType	string `json:"token_type"`
+	IDToken		string `json:"id_token"`
+	ExpiresIn	int `json:"expires_in"`
+}
+
+//IDTokenClaims are the verified claims of an ID token
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce			string `json:"nonce"`
+	AuthorizedParty	string `json:"azp"`
+	Email			string `json:"email"`
+	EmailVerified	bool `json:"email_verified"`
+	Name			string `json:"name"`
+}
+
+//errors returned while verifying an ID token
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrNonceMismatch = errors.New("id token nonce does not match")
+)
+
+//don't refetch the JWKS more often than this, so tokens with made up kids
+//can't be used to hammer the provider
+const minJWKSRefresh = time.Minute
+
+//Discover reads the provider's discovery document
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: config.HTTPClient,
+		keys: map[string]any{},
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.endpoints); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	//the document has to be about the issuer we asked for (OIDC Discovery 4.3)
+	if p.endpoints.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", p.endpoints.Issuer, config.Issuer)
+	}
+	if p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" || p.endpoints.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: document is missing endpoints")
+	}
+	return p, nil
+}
+
+//Issuer returns the issuer URL of the provider
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+//GeneratePKCE returns a random code verifier and its S256 code challenge
+func GeneratePKCE() (string, string, error) {
+	verifier, err := RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, CodeChallenge(verifier), nil
+}
+
+//CodeChallenge returns the S256 code challenge of a code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//RandomString returns 256 random bits, URL safe, for states, nonces and
+//code verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//AuthCodeURL returns the URL to send the user to for logging in
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	scopes := append([]string{"openid"}, p.config.Scopes...)
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id": {p.config.ClientID},
+		"redirect_uri": {p.config.RedirectURL},
+		"scope": {strings.Join(scopes, " ")},
+		"state": {state},
+		"nonce": {nonce},
+		"code_challenge": {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.endpoints.AuthorizationEndpoint + sep + q.Encode()
+}
+
+//Exchange trades an authorization code for tokens
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code": {code},
+		"redirect_uri": {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	//public clients have no secret and send their client ID in the body
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token request: %s: %s", resp.Status, body)
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("oidc token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+	return token, nil
+}
+
+//VerifyIDToken checks the signature of an ID token against the provider's
+//JWKS, its issuer, audience, expiry and nonce, and returns its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	token, err := jwt.ParseWithClaims(
+		rawIDToken,
+		&IDTokenClaims{},
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	claims, ok := token.Claims.(*IDTokenClaims)
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	//a token for several audiences has to name us as the authorized party
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: azp %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return claims, nil
+}
+
+//return the signing key with kid, fetching the JWKS when it's not known yet
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < minJWKSRefresh {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+//tokens without a kid are only accepted when the provider has a single key
+func (p *Provider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.endpoints.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		//skip encryption keys and key types we don't support
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc jwks has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
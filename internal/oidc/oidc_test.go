@@ -0,0 +1,360 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+//mockProvider is a minimal OpenID Connect provider running in the test
+type mockProvider struct {
+	t		*testing.T
+	server	*httptest.Server
+	kid		string
+	key		*rsa.PrivateKey
+
+	mu		sync.Mutex
+	codes	map[string]mockGrant
+}
+
+//what the user approved at the authorization endpoint
+type mockGrant struct {
+	challenge	string
+	nonce		string
+	subject		string
+	email		string
+}
+
+const (
+	testClientID = "chirpy"
+	testClientSecret = "s3cret"
+	testRedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+)
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{t: t, kid: "key-1", key: newTestRSAKey(t), codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request){
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer": m.issuer(),
+			"authorization_endpoint": m.issuer() + "/authorize",
+			"token_endpoint": m.issuer() + "/token",
+			"jwks_uri": m.issuer() + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request){
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n": base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", m.handleToken)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey err: %v", err)
+	}
+	return key
+}
+
+func (m *mockProvider) issuer() string {
+	return m.server.URL
+}
+
+//authorize stands in for the user logging in at the provider and returns the
+//code the provider would redirect back with
+func (m *mockProvider) authorize(authURL, subject, email string) (code, state string) {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("bad auth URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		m.t.Fatalf("unexpected auth request: %v", q)
+	}
+
+	code, _ = RandomString()
+	m.mu.Lock()
+	m.codes[code] = mockGrant{
+		challenge: q.Get("code_challenge"),
+		nonce: q.Get("nonce"),
+		subject: subject,
+		email: email,
+	}
+	m.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (m *mockProvider) handleToken(w http.ResponseWriter, r *http.Request){
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, 401)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, 400)
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || r.PostForm.Get("redirect_uri") != testRedirectURL ||
+		CodeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, 400)
+		return
+	}
+
+	idToken := m.signIDToken(jwt.MapClaims{
+		"iss": m.issuer(),
+		"sub": grant.subject,
+		"aud": testClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+		"email": grant.email,
+		"email_verified": true,
+	})
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "provider-access-token",
+		"token_type": "Bearer",
+		"id_token": idToken,
+		"expires_in": 60,
+	})
+}
+
+func (m *mockProvider) signIDToken(claims jwt.MapClaims) string {
+	m.t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("signing id token: %v", err)
+	}
+	return signed
+}
+
+func (m *mockProvider) discover(t *testing.T) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer: m.issuer(),
+		ClientID: testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL: testRedirectURL,
+		Scopes: []string{"email"},
+	})
+	if err != nil {
+		t.Fatalf("Discover err: %v", err)
+	}
+	return p
+}
+
+func TestLoginFlow(t *testing.T){
+	mock := newMockProvider(t)
+	p := mock.discover(t)
+
+	verifier, challenge, err := GeneratePKCE()
+	if err != nil {
+		t.Fatalf("GeneratePKCE err: %v", err)
+	}
+	authURL := p.AuthCodeURL("state-1", "nonce-1", challenge)
+	if !strings.HasPrefix(authURL, mock.issuer()+"/authorize?") || !strings.Contains(authURL, "scope=openid+email") {
+		t.Fatalf("unexpected auth URL %q", authURL)
+	}
+
+	code, state := mock.authorize(authURL, "user-123", "alice@example.com")
+	if state != "state-1" {
+		t.Fatalf("state = %q", state)
+	}
+
+	token, err := p.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange err: %v", err)
+	}
+	claims, err := p.VerifyIDToken(context.Background(), token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken err: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	//codes are single use
+	if _, err := p.Exchange(context.Background(), code, verifier); err == nil {
+		t.Fatalf("expected error reusing a code")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T){
+	mock := newMockProvider(t)
+	p := mock.discover(t)
+
+	_, challenge, _ := GeneratePKCE()
+	code, _ := mock.authorize(p.AuthCodeURL("s", "n", challenge), "user-123", "alice@example.com")
+
+	otherVerifier, _, _ := GeneratePKCE()
+	if _, err := p.Exchange(context.Background(), code, otherVerifier); err == nil {
+		t.Fatalf("expected error for wrong code verifier")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T){
+	mock := newMockProvider(t)
+	p := mock.discover(t)
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": mock.issuer(),
+			"sub": "user-123",
+			"aud": testClientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce-1",
+		}
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		c := valid()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), mock.signIDToken(valid()), "nonce-1"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	tests := []struct {
+		name	string
+		claims	jwt.MapClaims
+		wantErr	error
+	}{
+		{"wrong issuer", with("iss", "https://evil.example.com"), ErrInvalidIDToken},
+		{"wrong audience", with("aud", "someone-else"), ErrInvalidIDToken},
+		{"several audiences without azp", with("aud", []string{testClientID, "other"}), ErrInvalidIDToken},
+		{"expired", with("exp", time.Now().Add(-time.Hour).Unix()), ErrInvalidIDToken},
+		{"no expiry", with("exp", nil), ErrInvalidIDToken},
+		{"no subject", with("sub", nil), ErrInvalidIDToken},
+		{"wrong nonce", with("nonce", "nonce-2"), ErrNonceMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T){
+			_, err := p.VerifyIDToken(context.Background(), mock.signIDToken(tt.claims), "nonce-1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("signed by another key", func(t *testing.T){
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+		token.Header["kid"] = mock.kid
+		forged, _ := token.SignedString(newTestRSAKey(t))
+		if _, err := p.VerifyIDToken(context.Background(), forged, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Fatalf("err = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T){
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+		unsigned, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := p.VerifyIDToken(context.Background(), unsigned, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Fatalf("err = %v, want ErrInvalidIDToken", err)
+		}
+	})
+}
+
+func TestKeyRotation(t *testing.T){
+	mock := newMockProvider(t)
+	p := mock.discover(t)
+
+	claims := jwt.MapClaims{
+		"iss": mock.issuer(),
+		"sub": "user-123",
+		"aud": testClientID,
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"nonce": "n",
+	}
+	if _, err := p.VerifyIDToken(context.Background(), mock.signIDToken(claims), "n"); err != nil {
+		t.Fatalf("VerifyIDToken err: %v", err)
+	}
+
+	//the provider rotates to a new key
+	mock.mu.Lock()
+	mock.kid = "key-2"
+	mock.key = newTestRSAKey(t)
+	mock.mu.Unlock()
+	rotated := mock.signIDToken(claims)
+
+	//right after a fetch the new kid isn't looked up yet
+	if _, err := p.VerifyIDToken(context.Background(), rotated, "n"); err == nil {
+		t.Fatalf("expected error before the JWKS may be refetched")
+	}
+
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-minJWKSRefresh)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(context.Background(), rotated, "n"); err != nil {
+		t.Fatalf("token with rotated key rejected: %v", err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T){
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer": "https://someone-else.example.com",
+			"authorization_endpoint": "https://someone-else.example.com/authorize",
+			"token_endpoint": "https://someone-else.example.com/token",
+			"jwks_uri": "https://someone-else.example.com/jwks",
+		})
+	}))
+	defer server.Close()
+
+	if _, err := Discover(context.Background(), Config{Issuer: server.URL}); err == nil {
+		t.Fatalf("expected error for mismatched issuer")
+	}
+}
+
+func TestCodeChallenge(t *testing.T){
+	//example from RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("CodeChallenge = %q", got)
+	}
+}
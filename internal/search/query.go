@@ -0,0 +1,122 @@
+//Package search turns what users type into a search box into Postgres
+//full-text queries.
+package search
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//MaxQueryLength is the longest query in characters that is accepted
+const MaxQueryLength = 256
+
+//errors returned by ParseQuery
+var (
+	ErrEmptyQuery = errors.New("search query has no words to search for")
+	ErrQueryTooLong = errors.New("search query is too long")
+)
+
+//ParseQuery turns a search query into the syntax of Postgres' to_tsquery:
+//
+//	cat dog      chirps with both words
+//	cat OR dog   chirps with either word
+//	"black cat"  the words next to each other
+//	cat*         words starting with cat
+//	-dog         chirps without the word
+//
+//Anything that isn't a letter or digit separates words, so users can't
+//inject tsquery operators of their own.
+func ParseQuery(input string) (string, error) {
+	if utf8.RuneCountInString(input) > MaxQueryLength {
+		return "", ErrQueryTooLong
+	}
+
+	//terms joined by OR, the groups are joined by AND
+	var groups [][]string
+	positive := false
+	or := false
+
+	rest := strings.TrimSpace(input)
+	for rest != "" {
+		negate := false
+		if strings.HasPrefix(rest, "-") {
+			negate = true
+			rest = rest[1:]
+		}
+
+		var term string
+		if strings.HasPrefix(rest, `"`) {
+			//a phrase runs to the closing quote, or the end of the query
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				term, rest = phrase(rest[1:], false), ""
+			} else {
+				term, rest = phrase(rest[1:end+1], false), rest[end+2:]
+			}
+		} else {
+			token := rest
+			if end := strings.IndexFunc(rest, unicode.IsSpace); end != -1 {
+				token, rest = rest[:end], rest[end:]
+			} else {
+				rest = ""
+			}
+			if token == "OR" && !negate {
+				or = len(groups) > 0
+				rest = strings.TrimSpace(rest)
+				continue
+			}
+			prefix := strings.HasSuffix(token, "*")
+			term = phrase(strings.TrimRight(token, "*"), prefix)
+		}
+		rest = strings.TrimSpace(rest)
+
+		if term == "" {
+			continue
+		}
+		if negate {
+			term = "!" + term
+		} else {
+			positive = true
+		}
+
+		if or {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+		} else {
+			groups = append(groups, []string{term})
+		}
+		or = false
+	}
+
+	if !positive {
+		return "", ErrEmptyQuery
+	}
+
+	ands := make([]string, len(groups))
+	for i, group := range groups {
+		ands[i] = strings.Join(group, " | ")
+		if len(group) > 1 {
+			ands[i] = "(" + ands[i] + ")"
+		}
+	}
+	return strings.Join(ands, " & "), nil
+}
+
+//the words of s next to each other, the last one as a prefix when prefix is
+//set. Empty when s has no words.
+func phrase(s string, prefix bool) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
@@ -0,0 +1,67 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T){
+	tests := []struct {
+		input	string
+		want	string
+	}{
+		{input: "cat", want: "cat"},
+		{input: "  Cat   Dog ", want: "cat & dog"},
+		{input: "cat OR dog", want: "(cat | dog)"},
+		{input: "bird cat OR dog", want: "bird & (cat | dog)"},
+		{input: "cat OR dog OR fish", want: "(cat | dog | fish)"},
+		{input: `"black cat"`, want: "(black <-> cat)"},
+		{input: `"black cat" dog`, want: "(black <-> cat) & dog"},
+		{input: `"unclosed phrase`, want: "(unclosed <-> phrase)"},
+		{input: "chirp*", want: "chirp:*"},
+		{input: "cat -dog", want: "cat & !dog"},
+		{input: `cat -"hot dog"`, want: "cat & !(hot <-> dog)"},
+		//or is only an operator in capitals
+		{input: "cat or dog", want: "cat & or & dog"},
+		//a leading OR has nothing to join
+		{input: "OR cat", want: "cat"},
+		//words are split on anything but letters and digits
+		{input: "e-mail", want: "(e <-> mail)"},
+		{input: "héllo wörld 42", want: "héllo & wörld & 42"},
+		//tsquery syntax typed by the user is not passed through
+		{input: "cat & !dog | (fish:*)", want: "cat & dog & fish"},
+		{input: "cat')::tsquery; --", want: "(cat <-> tsquery)"},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseQuery(tc.input)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) err: %v", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseQuery(%q): want %q, got %q", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T){
+	tests := []struct {
+		input	string
+		want	error
+	}{
+		{input: "", want: ErrEmptyQuery},
+		{input: "   ", want: ErrEmptyQuery},
+		{input: `"" * - OR`, want: ErrEmptyQuery},
+		//only excluding words would match almost everything
+		{input: "-dog", want: ErrEmptyQuery},
+		{input: strings.Repeat("a", MaxQueryLength+1), want: ErrQueryTooLong},
+	}
+
+	for _, tc := range tests {
+		if _, err := ParseQuery(tc.input); !errors.Is(err, tc.want) {
+			t.Errorf("ParseQuery(%q): want %v, got %v", tc.input, tc.want, err)
+		}
+	}
+}
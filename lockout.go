@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//failed logins are tracked per account and per client IP
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+//check whether the account or the client is locked out, returning how long
+//until the latest lockout ends
+func (cfg *apiConfig) loginLockedFor(ctx context.Context, email, ip string) (time.Duration, error) {
+	lockouts, err := cfg.dbQueries.GetLoginLockouts(ctx, []string{accountLockoutKey(email), ipLockoutKey(ip)})
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, l := range lockouts {
+		if d := time.Until(l.LockedUntil.Time); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+//record a failed login for the account and the client, locking either out
+//once it passes its threshold
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, email, ip string) {
+	cfg.recordLockoutFailure(ctx, accountLockoutKey(email), cfg.accountLockout)
+	cfg.recordLockoutFailure(ctx, ipLockoutKey(ip), cfg.ipLockout)
+}
+
+func (cfg *apiConfig) recordLockoutFailure(ctx context.Context, key string, policy auth.LockoutPolicy) {
+	attempt, err := cfg.dbQueries.RecordLoginFailure(ctx, key)
+	if err != nil {
+		log.Printf("Error recording login failure: %v", err)
+		return
+	}
+
+	d := policy.Delay(int(attempt.Failures))
+	if d == 0 {
+		return
+	}
+	log.Printf("Locking out %v for %v after %d failed logins", key, d, attempt.Failures)
+	err = cfg.dbQueries.LockLogin(ctx, database.LockLoginParams{
+		Key: key,
+		LockedUntil: sql.NullTime{Time: time.Now().Add(d), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error locking out login: %v", err)
+	}
+}
+
+//respond with 429 telling the client when to try again
+func respondWithLockout(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, 429, "Too many failed login attempts, try again later")
+}
+
+//admin handler to clear the lockout of an account and/or a client IP
+func (cfg *apiConfig) handlerClearLockout(w http.ResponseWriter, r *http.Request){
+	type parameters struct {
+		Email	string `json:"email"`
+		IP		string `json:"ip"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+	if params.Email == "" && params.IP == "" {
+		respondWithError(w, 400, "email or ip is required")
+		return
+	}
+
+	var keys []string
+	if params.Email != "" {
+		keys = append(keys, accountLockoutKey(params.Email))
+	}
+	if params.IP != "" {
+		keys = append(keys, ipLockoutKey(params.IP))
+	}
+	for _, key := range keys {
+		if err := cfg.dbQueries.ClearLoginAttempts(r.Context(), key); err != nil {
+			log.Printf("Error clearing lockout: %v", err)
+			respondWithError(w, 500, "Error clearing lockout")
+			return
+		}
+	}
+
+	respondWithJSON(w, 204, "")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//how often servers pick up access tokens revoked on other servers
+const denylistSyncInterval = 15 * time.Second
+
+//log out: the access token used for the request stops working right away
+//instead of when it expires. Sending the refresh token too ends its session,
+//every token rotated from the same login included.
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+
+	type parameters struct {
+		RefreshToken	string `json:"refresh_token"`
+	}
+
+	//the body is optional
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	//only the caller's own sessions can be ended, someone else's refresh
+	//token looks the same as one that doesn't exist
+	var session uuid.NullUUID
+	if params.RefreshToken != "" {
+		info, err := cfg.dbQueries.GetUserFromRefreshToken(r.Context(), auth.HashToken(params.RefreshToken))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && info.UserID != principal.UserID {
+			respondWithError(w, 400, "Invalid refresh token")
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting refresh token: %v", err)
+			respondWithError(w, 500, "Error logging out")
+			return
+		}
+		session = uuid.NullUUID{UUID: info.FamilyID, Valid: true}
+	}
+
+	if err := cfg.denylist.Revoke(r.Context(), principal.Claims); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		respondWithError(w, 500, "Error logging out")
+		return
+	}
+
+	if session.Valid {
+		_, err := cfg.dbQueries.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+			FamilyID: session.UUID,
+			UserID: principal.UserID,
+		})
+		if err != nil {
+			log.Printf("Error revoking refresh token: %v", err)
+			respondWithError(w, 500, "Error logging out")
+			return
+		}
+	}
+
+	respondWithJSON(w, 204, "")
+}
+
+//denylistStore keeps the access token denylist in Postgres
+type denylistStore struct {
+	q	*database.Queries
+}
+
+func (s denylistStore) RevokeAccessToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	return s.q.RevokeAccessToken(ctx, database.RevokeAccessTokenParams{
+		Jti: jti,
+		UserID: userID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (s denylistStore) RevokedAccessTokensSince(ctx context.Context, since time.Time) ([]auth.RevokedToken, error) {
+	rows, err := s.q.GetRevokedAccessTokensSince(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	revoked := make([]auth.RevokedToken, len(rows))
+	for i, row := range rows {
+		revoked[i] = auth.RevokedToken{
+			JTI: row.Jti,
+			ExpiresAt: row.ExpiresAt,
+			RevokedAt: row.RevokedAt,
+		}
+	}
+	return revoked, nil
+}
+
+func (s denylistStore) DeleteExpiredRevokedAccessTokens(ctx context.Context) (int64, error) {
+	return s.q.DeleteExpiredRevokedAccessTokens(ctx)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+func TestLogout(t *testing.T){
+	userID := uuid.New()
+	const ownToken, othersToken = "own-refresh-token", "others-refresh-token"
+	family := uuid.New()
+	tests := []struct {
+		name			string
+		body			string
+		want			int
+		wantSession		bool
+	}{
+		{name: "access token only", body: "", want: 204},
+		{name: "own refresh token", body: `{"refresh_token":"` + ownToken + `"}`, want: 204, wantSession: true},
+		{name: "someone else's refresh token", body: `{"refresh_token":"` + othersToken + `"}`, want: 400},
+		{name: "unknown refresh token", body: `{"refresh_token":"nope"}`, want: 400},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("GetUserFromRefreshToken", func(args []driver.Value) fakeResult {
+			token := database.RefreshToken{FamilyID: family, UserID: userID}
+			switch args[0] {
+			case auth.HashToken(ownToken):
+			case auth.HashToken(othersToken):
+				token.UserID = uuid.New()
+			default:
+				return rowsOf()
+			}
+			return rowsOf(token)
+		})
+		db.on("RevokeAccessToken", func(args []driver.Value) fakeResult {
+			return affected(1)
+		})
+		db.on("RevokeUserSession", func(args []driver.Value) fakeResult {
+			return affected(1)
+		})
+		cfg := db.apiConfig()
+		cfg.denylist = auth.NewDenylist(denylistStore{q: cfg.dbQueries})
+
+		claims := &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+			ID: uuid.NewString(),
+			Subject: userID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}}
+		r := httptest.NewRequest("POST", "/api/logout", strings.NewReader(tc.body))
+		r = r.WithContext(auth.ContextWithPrincipal(r.Context(), &auth.Principal{UserID: userID, Claims: claims}))
+		w := httptest.NewRecorder()
+		cfg.handlerLogout(w, r)
+		if w.Code != tc.want {
+			t.Errorf("handlerLogout %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+
+		//a rejected logout leaves the access token working
+		if revoked := cfg.denylist.IsRevoked(claims.ID); revoked != (tc.want == 204) {
+			t.Errorf("handlerLogout %s: want access token revoked %v, got %v", tc.name, tc.want == 204, revoked)
+		}
+		calls := db.called("RevokeUserSession")
+		if !tc.wantSession {
+			if len(calls) != 0 {
+				t.Errorf("handlerLogout %s: want no session revoked, got %v", tc.name, calls)
+			}
+			continue
+		}
+		if len(calls) != 1 || calls[0][0] != family.String() || calls[0][1] != userID.String() {
+			t.Errorf("handlerLogout %s: want session %v of %v revoked, got %v", tc.name, family, userID, calls)
+		}
+	}
+}
@@ -8,6 +8,15 @@ import (
 	"github.com/paul39-33/chirpy/internal/database"
 	"database/sql"
 	"github.com/joho/godotenv"
+	"time"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/mailer"
+	"strconv"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"context"
+	"crypto/rand"
+	"github.com/paul39-33/chirpy/internal/oidc"
 )
 
 
@@ -25,13 +34,142 @@ func main(){
 	}
 	dbQueries := database.New(db)
 
+	//sign access tokens with the asymmetric keys in JWT_KEYS_DIR when set,
+	//otherwise fall back to HS256 with the shared secret
+	jwtKeys := auth.NewHMACKeyRing(secret)
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		jwtKeys, err = auth.LoadKeyRing(keysDir, os.Getenv("JWT_SIGNING_KEY_ID"), os.Getenv("JWT_LEGACY_SECRET"))
+		if err != nil {
+			log.Fatalf("Error loading JWT keys: %v", err)
+		}
+	}
+	//reject access tokens issued before the user's last password change
+	jwtKeys.SetTokenVersionFunc(dbQueries.GetUserTokenVersion)
+	//reject access tokens revoked by logging out
+	denylist := auth.NewDenylist(denylistStore{q: dbQueries})
+	jwtKeys.SetDenylist(denylist)
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		jwtKeys.SetIssuer(issuer)
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		jwtKeys.SetAudience(audience)
+	}
+	if skew := os.Getenv("JWT_CLOCK_SKEW"); skew != "" {
+		leeway, err := time.ParseDuration(skew)
+		if err != nil {
+			log.Fatalf("Invalid JWT_CLOCK_SKEW: %v", err)
+		}
+		jwtKeys.SetLeeway(leeway)
+	}
+
+	//emails are only logged (and optionally written to MAIL_DIR) unless
+	//MAILER=smtp is set
+	var mail mailer.Mailer = mailer.LogMailer{Dir: os.Getenv("MAIL_DIR")}
+	if os.Getenv("MAILER") == "smtp" {
+		mail = mailer.SMTPMailer{
+			Addr: os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From: os.Getenv("MAIL_FROM"),
+		}
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("Error loading password policy: %v", err)
+	}
+	passwordHasher, err := loadPasswordHasher()
+	if err != nil {
+		log.Fatalf("Error loading password hasher: %v", err)
+	}
+
+	accountDeletionGrace := defaultAccountDeletionGrace
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); v != "" {
+		accountDeletionGrace, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid ACCOUNT_DELETION_GRACE_PERIOD: %v", err)
+		}
+	}
+
+	//logins to unknown accounts check this hash, so they take as long as
+	//logins with a wrong password
+	dummyPasswordHash, err := passwordHasher.Hash(rand.Text())
+	if err != nil {
+		log.Fatalf("Error creating dummy password hash: %v", err)
+	}
+
+	chirpEditWindow := defaultChirpEditWindow
+	if v := os.Getenv("CHIRP_EDIT_WINDOW"); v != "" {
+		chirpEditWindow, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid CHIRP_EDIT_WINDOW: %v", err)
+		}
+	}
+
 	platform := os.Getenv("PLATFORM")
 	mux := http.NewServeMux()
 	apiCfg := apiConfig{
 		dbQueries: dbQueries,
 		platform: platform,
-		secret:	secret,
+		jwtKeys: jwtKeys,
 		polkaKey: polkaKey,
+		db: db,
+		mailer: mail,
+		baseURL: baseURL,
+		requireVerifiedEmail: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		passwordPolicy: passwordPolicy,
+		passwordHasher: passwordHasher,
+		accountDeletionGrace: accountDeletionGrace,
+		chirpEditWindow: chirpEditWindow,
+		denylist: denylist,
+		dummyPasswordHash: dummyPasswordHash,
+		//lock an account out after 5 failed logins and a client IP after 20,
+		//doubling the lockout on every further failure
+		accountLockout: auth.LockoutPolicy{
+			Threshold: 5,
+			BaseDelay: time.Minute,
+			MaxDelay: time.Hour,
+		},
+		ipLockout: auth.LockoutPolicy{
+			Threshold: 20,
+			BaseDelay: time.Minute,
+			MaxDelay: time.Hour,
+		},
+	}
+
+	//checks the access token of routes that need a logged in user
+	authn := auth.NewAuthenticator(jwtKeys)
+	authn.SetPersonalAccessTokenFunc(apiCfg.lookupPersonalAccessToken)
+
+	//subcommands like create-admin run against the database and exit
+	if len(os.Args) > 1 {
+		if err := apiCfg.runCommand(context.Background(), os.Args[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	//log in with an external OpenID Connect provider when OIDC_ISSUER is set
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = baseURL + "/api/auth/oidc/callback"
+		}
+		apiCfg.oidcProvider, err = oidc.Discover(context.Background(), oidc.Config{
+			Issuer: issuer,
+			ClientID: os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL: redirectURL,
+			Scopes: []string{"email", "profile"},
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		})
+		if err != nil {
+			log.Fatalf("Error discovering OIDC provider: %v", err)
+		}
 	}
 
 	//create a server variable
@@ -46,31 +184,89 @@ func main(){
 
 	mux.HandleFunc("GET /api/healthz", handlerHealthz)
 	
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.Handle("GET /admin/metrics", authn.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerMetrics)))
+
+	mux.Handle("POST /admin/reset", authn.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerReset)))
 
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.Handle("DELETE /admin/lockouts", authn.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.handlerClearLockout)))
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirps)
+	mux.Handle("POST /api/chirps", authn.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(apiCfg.handlerCreateChirps)))
 
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
+	mux.Handle("GET /api/chirps", authn.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(apiCfg.handlerGetChirps)))
 
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
+	mux.Handle("GET /api/chirps/search", authn.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(apiCfg.handlerSearchChirps)))
+
+	mux.Handle("GET /api/chirps/{chirpID}", authn.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(apiCfg.handlerGetChirp)))
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+
+	if apiCfg.oidcProvider != nil {
+		mux.HandleFunc("GET /api/auth/oidc/login", apiCfg.handlerOIDCLogin)
+
+		mux.HandleFunc("GET /api/auth/oidc/callback", apiCfg.handlerOIDCCallback)
+	}
+
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
 
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	mux.Handle("POST /api/logout", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerLogout)))
 
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	mux.Handle("PUT /api/users", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerUpdateUser)))
+
+	mux.Handle("DELETE /api/users", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerDeleteUser)))
+
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyEmail)
+
+	mux.Handle("POST /api/users/verify/resend", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerResendVerification)))
+
+	mux.Handle("POST /api/users/mfa/totp", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerEnrollTOTP)))
+
+	mux.Handle("POST /api/users/mfa/totp/confirm", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerConfirmTOTP)))
+
+	mux.Handle("DELETE /api/users/mfa/totp", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerDisableTOTP)))
+
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
+
+	mux.Handle("DELETE /api/chirps/{chirpID}", authn.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(apiCfg.handlerDeleteChirp)))
+
+	mux.Handle("PUT /api/chirps/{chirpID}", authn.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(apiCfg.handlerUpdateChirp)))
+
+	mux.Handle("GET /api/chirps/{chirpID}/revisions", authn.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(apiCfg.handlerGetChirpRevisions)))
+
+	mux.Handle("GET /api/chirps/{chirpID}/thread", authn.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(apiCfg.handlerGetChirpThread)))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerUpgradeUser)
 
-	
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+
+	mux.Handle("GET /api/sessions", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerGetSessions)))
+
+	mux.Handle("DELETE /api/sessions", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerRevokeAllSessions)))
+
+	mux.Handle("DELETE /api/sessions/{sessionID}", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerRevokeSession)))
+
+	mux.Handle("POST /api/tokens", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerCreateToken)))
+
+	mux.Handle("GET /api/tokens", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerGetTokens)))
+
+	mux.Handle("DELETE /api/tokens/{tokenID}", authn.RequireAuth(http.HandlerFunc(apiCfg.handlerRevokeToken)))
+
+	//load the tokens revoked before the server started, then keep in sync
+	//with other servers and drop expired entries
+	if err := denylist.Sync(context.Background()); err != nil {
+		log.Fatalf("Error loading access token denylist: %v", err)
+	}
+	go denylist.Run(context.Background(), denylistSyncInterval)
+
+	//hard delete accounts once their grace period is over
+	go apiCfg.purgeDeletedUsers(context.Background(), time.Hour)
 
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("HTTP server ListenAndServe: %v", err)
@@ -78,4 +274,77 @@ func main(){
 
 }
 
+//read the password policy settings, defaulting to 8 to 72 characters
+func loadPasswordPolicy() (auth.PasswordPolicy, error) {
+	policy := auth.PasswordPolicy{
+		MinLength: 8,
+		MaxLength: auth.MaxBcryptPasswordBytes,
+	}
 
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %w", err)
+		}
+		policy.MinLength = n
+	}
+	if v := os.Getenv("PASSWORD_MAX_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid PASSWORD_MAX_LENGTH: %w", err)
+		}
+		policy.MaxLength = n
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return policy, err
+		}
+		log.Printf("Loaded %d breached password hashes", breached.Len())
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+//pick the password hashing algorithm and its costs, argon2id by default
+func loadPasswordHasher() (auth.PasswordHasher, error) {
+	switch algorithm := os.Getenv("PASSWORD_HASHER"); algorithm {
+	case "", "argon2id":
+		hasher := auth.DefaultArgon2idHasher()
+		if v := os.Getenv("ARGON2_MEMORY_KIB"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ARGON2_MEMORY_KIB: %w", err)
+			}
+			hasher.Memory = uint32(n)
+		}
+		if v := os.Getenv("ARGON2_ITERATIONS"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid ARGON2_ITERATIONS: %q", v)
+			}
+			hasher.Iterations = uint32(n)
+		}
+		if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid ARGON2_PARALLELISM: %q", v)
+			}
+			hasher.Parallelism = uint8(n)
+		}
+		return hasher, nil
+	case "bcrypt":
+		hasher := auth.BcryptHasher{Cost: bcrypt.DefaultCost}
+		if v := os.Getenv("BCRYPT_COST"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+				return nil, fmt.Errorf("invalid BCRYPT_COST: %q", v)
+			}
+			hasher.Cost = n
+		}
+		return hasher, nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", algorithm)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+const (
+	//how long a user has to enter their code after the password step
+	mfaChallengeExp = 5 * time.Minute
+	//codes that can be tried per challenge before the user has to log in again
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount = 10
+)
+
+//check if the user has confirmed a TOTP enrollment
+func (cfg *apiConfig) userHasMFA(ctx context.Context, userID uuid.UUID) (bool, error) {
+	totp, err := cfg.dbQueries.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows){
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+//respond to the password step of a login with a short lived challenge token
+//that has to be exchanged at /api/login/mfa together with a code
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, user database.User){
+	challenge, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error creating MFA challenge: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	err = cfg.dbQueries.CreateMFAChallenge(r.Context(), database.CreateMFAChallengeParams{
+		TokenHash: auth.HashToken(challenge),
+		UserID: user.ID,
+		ExpiresAt: time.Now().Add(mfaChallengeExp),
+	})
+	if err != nil {
+		log.Printf("Error storing MFA challenge: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	type response struct {
+		MFARequired		bool `json:"mfa_required"`
+		ChallengeToken	string `json:"challenge_token"`
+	}
+
+	respondWithJSON(w, 200, response{
+		MFARequired: true,
+		ChallengeToken: challenge,
+	})
+}
+
+//second step of a login for users with two-factor authentication
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request){
+	type parameters struct {
+		ChallengeToken	string `json:"challenge_token"`
+		Code			string `json:"code"`
+		RecoveryCode	string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+	if params.Code == "" && params.RecoveryCode == "" {
+		respondWithError(w, 400, "code or recovery_code is required")
+		return
+	}
+
+	//count the attempt before checking the code, so parallel requests can't
+	//get past the limit
+	challengeHash := auth.HashToken(params.ChallengeToken)
+	challenge, err := cfg.dbQueries.ClaimMFAChallengeAttempt(r.Context(), database.ClaimMFAChallengeAttemptParams{
+		TokenHash: challengeHash,
+		MaxAttempts: mfaChallengeMaxAttempts,
+	})
+	if errors.Is(err, sql.ErrNoRows){
+		respondWithError(w, 401, "Invalid or expired challenge token, log in again")
+		return
+	}
+	if err != nil {
+		log.Printf("Error claiming MFA challenge attempt: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	//wrong codes count towards the same lockout as wrong passwords, a new
+	//challenge doesn't start the count over
+	ip := clientIP(r)
+	wait, err := cfg.loginLockedFor(r.Context(), user.Email, ip)
+	if err != nil {
+		log.Printf("Error checking login lockout: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if wait > 0 {
+		respondWithLockout(w, wait)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(r.Context(), user.ID, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("Error checking second factor: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(r.Context(), user.Email, ip)
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	//a challenge can only be exchanged for tokens once
+	used, err := cfg.dbQueries.UseMFAChallenge(r.Context(), challengeHash)
+	if err != nil {
+		log.Printf("Error using MFA challenge: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if used == 0 {
+		respondWithError(w, 401, "Invalid or expired challenge token")
+		return
+	}
+
+	//only both factors together reset the account's failure count
+	if err := cfg.dbQueries.ClearLoginAttempts(r.Context(), accountLockoutKey(user.Email)); err != nil {
+		log.Printf("Error clearing login attempts: %v", err)
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+//check a TOTP code or a recovery code for the user, marking it as used so it
+//can't be replayed
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, userID uuid.UUID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		used, err := cfg.dbQueries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+			UserID: userID,
+		})
+		return used == 1, err
+	}
+
+	totp, err := cfg.dbQueries.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	used, err := cfg.dbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		UserID: userID,
+		LastUsedStep: step,
+	})
+	return used == 1, err
+}
+
+//start a TOTP enrollment, returning the secret and a fresh set of recovery
+//codes. Two-factor authentication is only enabled once a code is confirmed.
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "Error retrieving user data")
+		return
+	}
+	enabled, err := cfg.userHasMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+	if enabled {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.UpsertUserTOTP(r.Context(), database.UpsertUserTOTPParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+	if err := qtx.DeleteUserRecoveryCodes(r.Context(), userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+	for _, code := range recoveryCodes {
+		err := qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+			UserID: userID,
+		})
+		if err != nil {
+			log.Printf("Error storing recovery code: %v", err)
+			respondWithError(w, 500, "Error enrolling two-factor authentication")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing TOTP enrollment: %v", err)
+		respondWithError(w, 500, "Error enrolling two-factor authentication")
+		return
+	}
+
+	type response struct {
+		Secret			string `json:"secret"`
+		OTPAuthURI		string `json:"otpauth_uri"`
+		RecoveryCodes	[]string `json:"recovery_codes"`
+	}
+
+	respondWithJSON(w, 201, response{
+		Secret: secret,
+		OTPAuthURI: auth.TOTPURI("Chirpy", user.Email, secret),
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+//enable two-factor authentication by proving the authenticator app works
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	type parameters struct {
+		Code	string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	totp, err := cfg.dbQueries.GetUserTOTP(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows){
+		respondWithError(w, 404, "No two-factor enrollment in progress")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting TOTP enrollment: %v", err)
+		respondWithError(w, 500, "Error confirming two-factor authentication")
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	err = cfg.dbQueries.ConfirmUserTOTP(r.Context(), database.ConfirmUserTOTPParams{
+		UserID: userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		log.Printf("Error confirming TOTP enrollment: %v", err)
+		respondWithError(w, 500, "Error confirming two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
+
+//turn two-factor authentication off, which needs a current code
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	type parameters struct {
+		Code			string `json:"code"`
+		RecoveryCode	string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	enabled, err := cfg.userHasMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	if !enabled {
+		respondWithError(w, 404, "Two-factor authentication is not enabled")
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(r.Context(), userID, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("Error checking second factor: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	if err := qtx.DeleteUserTOTP(r.Context(), userID); err != nil {
+		log.Printf("Error deleting TOTP enrollment: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	if err := qtx.DeleteUserRecoveryCodes(r.Context(), userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing TOTP removal: %v", err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"github.com/paul39-33/chirpy/internal/oidc"
+)
+
+const (
+	//how long a user has to log in at the identity provider
+	oidcLoginStateExp = 10 * time.Minute
+	//binds the callback to the browser that started the login
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var (
+	errOIDCEmailNotVerified = errors.New("identity provider did not return a verified email")
+	errOIDCAccountNotVerified = errors.New("existing account with this email is not verified")
+)
+
+//start a login with the identity provider: remember the state, nonce and
+//PKCE verifier and send the user to the provider
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request){
+	//logins that were never finished pile up otherwise
+	if err := cfg.dbQueries.DeleteExpiredOIDCLoginStates(r.Context()); err != nil {
+		log.Printf("Error deleting expired OIDC login states: %v", err)
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		log.Printf("Error creating OIDC state: %v", err)
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		log.Printf("Error creating OIDC nonce: %v", err)
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+	verifier, challenge, err := oidc.GeneratePKCE()
+	if err != nil {
+		log.Printf("Error creating PKCE verifier: %v", err)
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+
+	err = cfg.dbQueries.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash: auth.HashToken(state),
+		Nonce: nonce,
+		CodeVerifier: verifier,
+		ExpiresAt: time.Now().Add(oidcLoginStateExp),
+	})
+	if err != nil {
+		log.Printf("Error storing OIDC login state: %v", err)
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+
+	http.SetCookie(w, cfg.oidcStateCookie(state, int(oidcLoginStateExp.Seconds())))
+	http.Redirect(w, r, cfg.oidcProvider.AuthCodeURL(state, nonce, challenge), http.StatusFound)
+}
+
+//the identity provider redirects back here with an authorization code
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request){
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC login failed at the provider: %s %s", providerErr, query.Get("error_description"))
+		respondWithError(w, 401, "Login with the identity provider failed")
+		return
+	}
+
+	//the state has to come back to the same browser, otherwise someone could
+	//log the user in to the attacker's account
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, 400, "Invalid login state")
+		return
+	}
+	http.SetCookie(w, cfg.oidcStateCookie("", -1))
+
+	loginState, err := cfg.dbQueries.UseOIDCLoginState(r.Context(), auth.HashToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Login expired, please try again")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting OIDC login state: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	token, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %v", err)
+		respondWithError(w, 401, "Login with the identity provider failed")
+		return
+	}
+	claims, err := cfg.oidcProvider.VerifyIDToken(r.Context(), token.IDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("Error verifying ID token: %v", err)
+		respondWithError(w, 401, "Login with the identity provider failed")
+		return
+	}
+
+	user, err := cfg.userForIdentity(r.Context(), claims)
+	if errors.Is(err, errOIDCEmailNotVerified) {
+		respondWithError(w, 403, "The identity provider did not confirm your email address")
+		return
+	}
+	if errors.Is(err, errOIDCAccountNotVerified) {
+		respondWithError(w, 409, "An account with this email exists, verify its email before logging in with the identity provider")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user for OIDC identity: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+
+	//two-factor authentication still applies to linked accounts
+	mfaEnabled, err := cfg.userHasMFA(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		respondWithError(w, 500, "Error logging in")
+		return
+	}
+	if mfaEnabled {
+		cfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+//return the user linked to the provider's subject. Unknown subjects are
+//linked to the account with the same email, or get a new account, but only
+//when the provider says it verified the email.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, claims *oidc.IDTokenClaims) (database.User, error) {
+	identity, err := cfg.dbQueries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Issuer: cfg.oidcProvider.Issuer(),
+		Subject: claims.Subject,
+	})
+	if err == nil {
+		return cfg.dbQueries.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, errOIDCEmailNotVerified
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	user, err := qtx.UserLogin(ctx, claims.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		//no password, the account can only log in through the provider until
+		//the user resets it
+		user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			HashedPassword: "",
+			Email: claims.Email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		if err := qtx.MarkEmailVerified(ctx, user.ID); err != nil {
+			return database.User{}, err
+		}
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerifiedAt.Valid:
+		//anyone could have signed up with this email, linking would hand the
+		//account's password to whoever did
+		return database.User{}, errOIDCAccountNotVerified
+	}
+
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		UserID: user.ID,
+		Issuer: cfg.oidcProvider.Issuer(),
+		Subject: claims.Subject,
+		Email: claims.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+//the state cookie, maxAge -1 deletes it
+func (cfg *apiConfig) oidcStateCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name: oidcStateCookie,
+		Value: state,
+		Path: "/api/auth/oidc/",
+		MaxAge: maxAge,
+		HttpOnly: true,
+		Secure: strings.HasPrefix(cfg.baseURL, "https://"),
+		//Lax so the cookie is sent on the provider's redirect back to us
+		SameSite: http.SameSiteLaxMode,
+	}
+}
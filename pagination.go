@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//page sizes of list endpoints, ?limit= can ask for up to maxPageSize items
+const (
+	defaultPageSize = 20
+	maxPageSize = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+//the last chirp of a page. Lists are ordered by (created_at, id), so the next
+//page starts right after it no matter what was posted in the meantime.
+type chirpCursor struct {
+	CreatedAt	time.Time `json:"t"`
+	ID			uuid.UUID `json:"id"`
+}
+
+//clients treat cursors as opaque strings
+func encodeChirpCursor(createdAt time.Time, id uuid.UUID) string {
+	data, _ := json.Marshal(chirpCursor{CreatedAt: createdAt.UTC(), ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//decode a cursor from the query, an empty one means the first page
+func decodeChirpCursor(s string) (sql.NullTime, uuid.NullUUID, error) {
+	if s == "" {
+		return sql.NullTime{}, uuid.NullUUID{}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return sql.NullTime{}, uuid.NullUUID{}, errInvalidCursor
+	}
+	var c chirpCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.CreatedAt.IsZero() {
+		return sql.NullTime{}, uuid.NullUUID{}, errInvalidCursor
+	}
+	return sql.NullTime{Time: c.CreatedAt, Valid: true}, uuid.NullUUID{UUID: c.ID, Valid: true}, nil
+}
+
+//the path of the last reply of a page of a thread. Threads are ordered by
+//path, so the next page starts right after it.
+func encodeThreadCursor(path []string) string {
+	data, _ := json.Marshal(path)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//decode a thread cursor from the query, an empty one means the first page
+func decodeThreadCursor(s string) ([]string, error) {
+	//an empty path sorts before every reply; nil would be NULL and match none
+	if s == "" {
+		return []string{}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var path []string
+	if err := json.Unmarshal(data, &path); err != nil || len(path) == 0 || len(path) > maxThreadDepth {
+		return nil, errInvalidCursor
+	}
+	return path, nil
+}
+
+//parse ?limit=, missing means defaultPageSize and anything over maxPageSize
+//gets maxPageSize
+func parsePageSize(s string) (int32, error) {
+	if s == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("limit must be a positive number")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return int32(limit), nil
+}
+
+//parse ?sort=, reporting whether the list is newest first
+func parseSortOrder(s string) (bool, error) {
+	switch s {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	default:
+		return false, fmt.Errorf(`sort must be "asc" or "desc"`)
+	}
+}
+
+//point to the next page in a Link header (RFC 8288), keeping the other
+//query parameters of the request
+func (cfg *apiConfig) setNextPageLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := cfg.baseURL + r.URL.Path + "?" + query.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+func encodeJSONCursor(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal err: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestDecodeChirpCursor(t *testing.T){
+	chirpID := uuid.New()
+	chirpCreatedAt := time.Date(2025, 10, 27, 9, 30, 0, 123000, time.UTC)
+
+	createdAt, id, err := decodeChirpCursor(encodeChirpCursor(chirpCreatedAt, chirpID))
+	if err != nil {
+		t.Fatalf("decodeChirpCursor err: %v", err)
+	}
+	if !createdAt.Valid || !createdAt.Time.Equal(chirpCreatedAt) || !id.Valid || id.UUID != chirpID {
+		t.Errorf("decodeChirpCursor: want (%v, %v), got (%v, %v)", chirpCreatedAt, chirpID, createdAt, id)
+	}
+
+	//the first page has no cursor
+	createdAt, id, err = decodeChirpCursor("")
+	if err != nil || createdAt.Valid || id.Valid {
+		t.Errorf(`decodeChirpCursor(""): want no cursor, got (%v, %v, %v)`, createdAt, id, err)
+	}
+
+	tests := []struct {
+		name	string
+		input	string
+	}{
+		{"bad base64", "not base64!"},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("cursor"))},
+		{"zero time", encodeJSONCursor(t, chirpCursor{ID: chirpID})},
+		{"no time", encodeJSONCursor(t, map[string]string{"id": chirpID.String()})},
+		{"bad id", encodeJSONCursor(t, map[string]string{"t": "2025-10-27T09:30:00Z", "id": "abc"})},
+	}
+	for _, tc := range tests {
+		if _, _, err := decodeChirpCursor(tc.input); !errors.Is(err, errInvalidCursor) {
+			t.Errorf("decodeChirpCursor %s: want %v, got %v", tc.name, errInvalidCursor, err)
+		}
+	}
+}
+
+func TestParsePageSize(t *testing.T){
+	tests := []struct {
+		input	string
+		want	int32
+		wantErr	bool
+	}{
+		{input: "", want: defaultPageSize},
+		{input: "1", want: 1},
+		{input: "50", want: 50},
+		{input: "100", want: maxPageSize},
+		//too large asks for the most there is, not an error
+		{input: "101", want: maxPageSize},
+		{input: "0", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "ten", wantErr: true},
+		{input: "1.5", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parsePageSize(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parsePageSize(%q) err: %v, want error %v", tc.input, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parsePageSize(%q): want %d, got %d", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestParseSortOrder(t *testing.T){
+	tests := []struct {
+		input		string
+		wantDesc	bool
+		wantErr		bool
+	}{
+		{input: "", wantDesc: false},
+		{input: "asc", wantDesc: false},
+		{input: "desc", wantDesc: true},
+		{input: "DESC", wantErr: true},
+		{input: "newest", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseSortOrder(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseSortOrder(%q) err: %v, want error %v", tc.input, err, tc.wantErr)
+			continue
+		}
+		if got != tc.wantDesc {
+			t.Errorf("parseSortOrder(%q): want %v, got %v", tc.input, tc.wantDesc, got)
+		}
+	}
+}
+
+func TestDecodeThreadCursor(t *testing.T){
+	path := []string{"20251106100000000000" + uuid.NewString(), "20251106100500000000" + uuid.NewString()}
+	got, err := decodeThreadCursor(encodeThreadCursor(path))
+	if err != nil {
+		t.Fatalf("decodeThreadCursor err: %v", err)
+	}
+	if strings.Join(got, ",") != strings.Join(path, ",") {
+		t.Errorf("decodeThreadCursor: want %v, got %v", path, got)
+	}
+
+	//the first page starts after an empty path, which must not be nil
+	got, err = decodeThreadCursor("")
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf(`decodeThreadCursor(""): want empty path, got (%#v, %v)`, got, err)
+	}
+
+	tests := []struct {
+		name	string
+		input	string
+	}{
+		{"bad base64", "not base64!"},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("path"))},
+		{"empty path", encodeJSONCursor(t, []string{})},
+		{"too deep", encodeJSONCursor(t, make([]string, maxThreadDepth+1))},
+	}
+	for _, tc := range tests {
+		if _, err := decodeThreadCursor(tc.input); !errors.Is(err, errInvalidCursor) {
+			t.Errorf("decodeThreadCursor %s: want %v, got %v", tc.name, errInvalidCursor, err)
+		}
+	}
+}
+
+func TestGetChirpsPages(t *testing.T){
+	first := time.Date(2025, 10, 27, 9, 30, 0, 0, time.UTC)
+	chirps := make([]database.ListChirpsRow, 3)
+	for i := range chirps {
+		chirps[i] = database.ListChirpsRow{
+			ID: uuid.New(),
+			CreatedAt: first.Add(time.Duration(i) * time.Minute),
+			Body: "chirp",
+			UserID: uuid.New(),
+		}
+	}
+	db := newFakeDB(t)
+	db.on("ListChirps", func(args []driver.Value) fakeResult {
+		//the chirps after the cursor, up to the page size
+		rest := chirps
+		if args[2] != nil {
+			for i, c := range chirps {
+				if c.ID.String() == args[2] {
+					rest = chirps[i+1:]
+				}
+			}
+		}
+		values := []any{}
+		for i := 0; i < len(rest) && i < int(args[3].(int64)); i++ {
+			values = append(values, rest[i])
+		}
+		return rowsOf(values...)
+	})
+	cfg := db.apiConfig()
+
+	var got []uuid.UUID
+	cursor := ""
+	for page := 0; page < len(chirps); page++ {
+		w := httptest.NewRecorder()
+		cfg.handlerGetChirps(w, httptest.NewRequest("GET", "/api/chirps?limit=2&cursor="+url.QueryEscape(cursor), nil))
+		if w.Code != 200 {
+			t.Fatalf("handlerGetChirps page %d: want 200, got %d %s", page, w.Code, w.Body)
+		}
+		var resp ChirpPage
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding page err: %v", err)
+		}
+		for _, c := range resp.Chirps {
+			got = append(got, c.ID)
+		}
+		if resp.NextCursor == nil {
+			break
+		}
+		cursor = *resp.NextCursor
+	}
+	if len(got) != len(chirps) || got[0] != chirps[0].ID || got[2] != chirps[2].ID {
+		t.Errorf("handlerGetChirps: want every chirp once in order, got %v", got)
+	}
+}
+
+func TestGetChirpsInvalidParams(t *testing.T){
+	tests := []struct {
+		name	string
+		query	string
+	}{
+		{"garbage cursor", "cursor=garbage!"},
+		{"tampered cursor", "cursor=" + encodeJSONCursor(t, map[string]string{"t": "2025-10-27T09:30:00Z", "id": "'; DROP TABLE chirps; --"})},
+		{"thread cursor", "cursor=" + encodeThreadCursor([]string{"20251106100000000000" + uuid.NewString()})},
+		{"bad limit", "limit=many"},
+		{"bad sort", "sort=newest"},
+		{"bad author", "author_id=walt"},
+	}
+
+	for _, tc := range tests {
+		//bad parameters are turned away before any query runs
+		cfg := newFakeDB(t).apiConfig()
+		w := httptest.NewRecorder()
+		cfg.handlerGetChirps(w, httptest.NewRequest("GET", "/api/chirps?"+tc.query, nil))
+		if w.Code != 400 {
+			t.Errorf("handlerGetChirps %s: want 400, got %d %s", tc.name, w.Code, w.Body)
+		}
+	}
+}
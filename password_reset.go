@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"github.com/paul39-33/chirpy/internal/mailer"
+)
+
+//password reset links are only valid for a short time
+const passwordResetExp = 30 * time.Minute
+
+//start a password reset. The response is the same whether or not the email
+//belongs to an account, so it can't be used to find out who is registered.
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request){
+	type parameters struct {
+		Email	string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	//look the user up and send the email in the background so the response
+	//time doesn't give away whether the account exists either
+	go cfg.sendPasswordResetEmail(context.WithoutCancel(r.Context()), params.Email)
+
+	respondWithJSON(w, 202, "")
+}
+
+//email a new password reset token if the email belongs to an account
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, email string) {
+	user, err := cfg.dbQueries.UserLogin(ctx, email)
+	if errors.Is(err, sql.ErrNoRows){
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user for password reset: %v", err)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error creating password reset token: %v", err)
+		return
+	}
+
+	//only the newest link should work
+	if err := cfg.dbQueries.DeleteUserPasswordResetTokens(ctx, user.ID); err != nil {
+		log.Printf("Error deleting old password reset tokens: %v", err)
+		return
+	}
+	err = cfg.dbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID: user.ID,
+		ExpiresAt: time.Now().Add(passwordResetExp),
+	})
+	if err != nil {
+		log.Printf("Error storing password reset token: %v", err)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To: user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"To choose a new password, send this token with your new password to POST %s/api/password/reset:\n\n%s\n\n"+
+			"The token expires in %v. If you didn't ask for this, you can ignore this email.", cfg.baseURL, token, passwordResetExp),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+}
+
+//set a new password using a token from a password reset email
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request){
+	type parameters struct {
+		Token		string `json:"token"`
+		Password	string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userID, err := qtx.UsePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	//unknown, used and expired tokens all look the same
+	if errors.Is(err, sql.ErrNoRows){
+		respondWithError(w, 400, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		log.Printf("Error using password reset token: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+
+	//a rejected password rolls the transaction back, so the token stays usable
+	user, err := qtx.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, user.Email) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(params.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		respondWithError(w, 400, "Error hashing password")
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID: userID,
+	})
+	if err != nil {
+		log.Printf("Error updating password: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+	//log the user out everywhere, whoever knew the old password included
+	if err := revokeUserCredentials(r.Context(), qtx, userID); err != nil {
+		log.Printf("Error revoking user credentials: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+	if err := qtx.DeleteUserPasswordResetTokens(r.Context(), userID); err != nil {
+		log.Printf("Error deleting password reset tokens: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+	//the token came from the user's inbox, which proves they own the address
+	if err := qtx.MarkEmailVerified(r.Context(), userID); err != nil {
+		log.Printf("Error marking email verified: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing password reset: %v", err)
+		respondWithError(w, 500, "Error resetting password")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"github.com/paul39-33/chirpy/internal/mailer"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//fakeMailer keeps the messages it is asked to send
+type fakeMailer struct {
+	mu		sync.Mutex
+	sent	[]mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func testUser(email string) database.User {
+	now := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	return database.User{
+		ID: uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Email: email,
+		HashedPassword: "hash",
+		Role: auth.RoleUser,
+	}
+}
+
+func TestSendPasswordResetEmail(t *testing.T){
+	user := testUser("walt@example.com")
+	db := newFakeDB(t)
+	db.on("UserLogin", func(args []driver.Value) fakeResult {
+		if args[0] != user.Email {
+			return rowsOf()
+		}
+		return rowsOf(user)
+	})
+	db.on("DeleteUserPasswordResetTokens", func(args []driver.Value) fakeResult {
+		return affected(1)
+	})
+	db.on("CreatePasswordResetToken", func(args []driver.Value) fakeResult {
+		return affected(1)
+	})
+	cfg := db.apiConfig()
+	mail := &fakeMailer{}
+	cfg.mailer = mail
+
+	//unknown emails get nothing
+	cfg.sendPasswordResetEmail(context.Background(), "nobody@example.com")
+	if len(mail.sent) != 0 || len(db.called("CreatePasswordResetToken")) != 0 {
+		t.Fatalf("sendPasswordResetEmail to an unknown email: want nothing sent, got %v", mail.sent)
+	}
+
+	cfg.sendPasswordResetEmail(context.Background(), user.Email)
+	if len(mail.sent) != 1 || mail.sent[0].To != user.Email {
+		t.Fatalf("sendPasswordResetEmail: want one email to %s, got %v", user.Email, mail.sent)
+	}
+	//older links stop working and only the hash of the mailed token is kept
+	if len(db.called("DeleteUserPasswordResetTokens")) != 1 {
+		t.Errorf("sendPasswordResetEmail: want the older tokens deleted")
+	}
+	created := db.called("CreatePasswordResetToken")
+	if len(created) != 1 {
+		t.Fatalf("sendPasswordResetEmail: want one token stored, got %v", created)
+	}
+	tokenHash := created[0][0].(string)
+	lines := strings.Split(mail.sent[0].Body, "\n")
+	found := false
+	for _, line := range lines {
+		if line != "" && auth.HashToken(line) == tokenHash {
+			found = true
+		}
+	}
+	if !found || strings.Contains(mail.sent[0].Body, tokenHash) {
+		t.Errorf("sendPasswordResetEmail: want the token whose hash was stored in the email, got %q", mail.sent[0].Body)
+	}
+	if expiresAt := created[0][2].(time.Time); time.Until(expiresAt) > passwordResetExp {
+		t.Errorf("sendPasswordResetEmail: want the token to expire within %v, got %v", passwordResetExp, expiresAt)
+	}
+}
+
+func TestResetPassword(t *testing.T){
+	user := testUser("walt@example.com")
+	const token = "reset-token"
+	tests := []struct {
+		name		string
+		token		string
+		password	string
+		want		int
+	}{
+		{name: "valid", token: token, password: "a new passphrase", want: 204},
+		{name: "unknown token", token: "guessed", password: "a new passphrase", want: 400},
+		{name: "password too short", token: token, password: "short", want: 400},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("UsePasswordResetToken", func(args []driver.Value) fakeResult {
+			if args[0] != auth.HashToken(token) {
+				return rowsOf()
+			}
+			return rowsOf(user.ID)
+		})
+		db.on("GetUserByID", func(args []driver.Value) fakeResult {
+			return rowsOf(user)
+		})
+		for _, name := range []string{"UpdateUserPassword", "IncrementTokenVersion", "RevokeAllUserPersonalAccessTokens",
+			"RevokeAllUserRefreshTokens", "DeleteUserPasswordResetTokens", "MarkEmailVerified"} {
+			db.on(name, func(args []driver.Value) fakeResult {
+				return affected(1)
+			})
+		}
+		cfg := db.apiConfig()
+		cfg.passwordPolicy = auth.PasswordPolicy{MinLength: 8, MaxLength: auth.MaxBcryptPasswordBytes}
+		cfg.passwordHasher = auth.BcryptHasher{Cost: bcrypt.MinCost}
+
+		body := `{"token":"` + tc.token + `","password":"` + tc.password + `"}`
+		w := httptest.NewRecorder()
+		cfg.handlerResetPassword(w, httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(body)))
+		if w.Code != tc.want {
+			t.Errorf("handlerResetPassword %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+			continue
+		}
+
+		//a rejected reset changes nothing, the token included
+		committed := len(db.called("COMMIT")) == 1
+		if committed != (tc.want == 204) {
+			t.Errorf("handlerResetPassword %s: want committed %v, got %v", tc.name, tc.want == 204, committed)
+		}
+		if tc.want != 204 {
+			continue
+		}
+		updated := db.called("UpdateUserPassword")
+		if len(updated) != 1 || auth.CheckPasswordHash(tc.password, updated[0][0].(string)) != nil {
+			t.Errorf("handlerResetPassword %s: want the new password stored, got %v", tc.name, updated)
+		}
+		//every session ends, whoever knew the old password included
+		for _, name := range []string{"IncrementTokenVersion", "RevokeAllUserPersonalAccessTokens", "RevokeAllUserRefreshTokens"} {
+			if calls := db.called(name); len(calls) != 1 || calls[0][0] != user.ID.String() {
+				t.Errorf("handlerResetPassword %s: want %s for %v, got %v", tc.name, name, user.ID, calls)
+			}
+		}
+	}
+}
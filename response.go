@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/paul39-33/chirpy/internal/auth"
 )
 
 //json error struct
@@ -16,6 +19,28 @@ func respondWithError(w http.ResponseWriter, code int, msg string){
 	_ = json.NewEncoder(w).Encode(returnErr{Error: msg})
 }
 
+//json error struct listing every failed validation rule
+type returnValidationErr struct {
+	Error		string `json:"error"`
+	Violations	[]string `json:"violations"`
+}
+
+func respondWithValidationError(w http.ResponseWriter, msg string, violations []string){
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	_ = json.NewEncoder(w).Encode(returnValidationErr{Error: msg, Violations: violations})
+}
+
+//an Authorization header that can't be parsed is a bad request, a missing one
+//or one with another scheme means the caller isn't authenticated
+func respondWithAuthHeaderError(w http.ResponseWriter, err error){
+	if errors.Is(err, auth.ErrMalformedAuthHeader) {
+		respondWithError(w, 400, "Malformed Authorization header")
+		return
+	}
+	respondWithError(w, 401, "Bearer token required")
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}){
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
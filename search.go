@@ -0,0 +1,105 @@
+package main
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+	"github.com/paul39-33/chirpy/internal/search"
+)
+
+//a chirp matching a search
+type ChirpSearchResult struct {
+	Chirp
+	//relevance to the query, higher is better
+	Rank	float32 `json:"rank"`
+	//the matching parts of the body, HTML escaped, with matches in <mark> tags
+	Snippet	string `json:"snippet"`
+}
+
+type ChirpSearchResults struct {
+	Chirps	[]ChirpSearchResult `json:"chirps"`
+}
+
+//SearchChirps has ts_headline wrap matches in \x02 and \x03 so the snippet
+//can be escaped before the tags go in. Neither can come from the body: new
+//chirps have control characters stripped and the query drops them from older
+//ones.
+var snippetHighlighter = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+//search chirps with Postgres full-text search, best matches first unless
+//?sort=recent, optionally by one author
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request){
+	query := r.URL.Query()
+
+	tsquery, err := search.ParseQuery(query.Get("q"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	var byRelevance bool
+	switch query.Get("sort") {
+	case "", "relevance":
+		byRelevance = true
+	case "recent":
+		byRelevance = false
+	default:
+		respondWithError(w, 400, `sort must be "relevance" or "recent"`)
+		return
+	}
+
+	var author uuid.NullUUID
+	if authorID := query.Get("author_id"); authorID != "" {
+		id, err := uuid.Parse(authorID)
+		if err != nil {
+			log.Printf("Error parsing author ID from string to UUID: %v", err)
+			respondWithError(w, 400, "Error parsing author ID")
+			return
+		}
+		author = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	rows, err := cfg.dbQueries.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query: tsquery,
+		AuthorID: author,
+		ByRelevance: byRelevance,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Printf("Error searching chirps: %v", err)
+		respondWithError(w, 500, "Error searching chirps")
+		return
+	}
+
+	resp := ChirpSearchResults{Chirps: make([]ChirpSearchResult, len(rows))}
+	for i, c := range rows {
+		resp.Chirps[i] = ChirpSearchResult{
+			Chirp: Chirp{
+				ID: c.ID,
+				CreatedAt: c.CreatedAt,
+				UpdatedAt: c.UpdatedAt,
+				Body: c.Body,
+				UserID: c.UserID,
+				Edited: c.EditCount > 0,
+				EditCount: c.EditCount,
+				InReplyTo: nullUUIDPtr(c.ParentID),
+				RootID: nullUUIDPtr(c.RootID),
+				ReplyCount: c.ReplyCount,
+			},
+			Rank: c.Rank,
+			Snippet: snippetHighlighter.Replace(html.EscapeString(c.Snippet)),
+		}
+	}
+
+	respondWithJSON(w, 200, resp)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//a session is one login, i.e. one refresh token family
+type Session struct {
+	ID			uuid.UUID `json:"id"`
+	CreatedAt	time.Time `json:"created_at"`
+	LastUsedAt	time.Time `json:"last_used_at"`
+	ExpiresAt	time.Time `json:"expires_at"`
+	UserAgent	string `json:"user_agent"`
+	IPAddress	string `json:"ip_address"`
+}
+
+//list the active sessions of the logged in user
+func (cfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	sessions, err := cfg.dbQueries.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting sessions: %v", err)
+		respondWithError(w, 500, "Error getting sessions")
+		return
+	}
+
+	resp := make([]Session, len(sessions))
+	for i, s := range sessions {
+		resp[i] = Session{
+			ID: s.FamilyID,
+			CreatedAt: s.StartedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt: s.ExpiresAt,
+			UserAgent: s.UserAgent,
+			IPAddress: s.IpAddress,
+		}
+	}
+
+	respondWithJSON(w, 200, resp)
+}
+
+//revoke a single session of the logged in user
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		log.Printf("Error parsing session ID from string to UUID: %v", err)
+		respondWithError(w, 400, "Error parsing session ID")
+		return
+	}
+
+	//only sessions of the caller can be revoked, so someone else's session
+	//looks the same as one that doesn't exist
+	revoked, err := cfg.dbQueries.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		FamilyID: sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		respondWithError(w, 500, "Error revoking session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "session not found")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
+
+//revoke every session of the logged in user
+func (cfg *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	if err := cfg.dbQueries.RevokeAllUserRefreshTokens(r.Context(), userID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		respondWithError(w, 500, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
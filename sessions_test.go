@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+func TestGetSessions(t *testing.T){
+	userID := uuid.New()
+	started := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	session := database.GetUserSessionsRow{
+		FamilyID: uuid.New(),
+		StartedAt: started,
+		LastUsedAt: started.Add(time.Hour),
+		ExpiresAt: started.Add(refreshTokenExp),
+		UserAgent: "curl/8.5.0",
+		IpAddress: "203.0.113.7",
+	}
+	db := newFakeDB(t)
+	db.on("GetUserSessions", func(args []driver.Value) fakeResult {
+		return rowsOf(session)
+	})
+	cfg := db.apiConfig()
+
+	w := httptest.NewRecorder()
+	cfg.handlerGetSessions(w, authedRequest("GET", "/api/sessions", nil, userID))
+	if w.Code != 200 {
+		t.Fatalf("handlerGetSessions: want 200, got %d %s", w.Code, w.Body)
+	}
+	var got []Session
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding sessions err: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != session.FamilyID || !got[0].CreatedAt.Equal(started) || got[0].UserAgent != session.UserAgent {
+		t.Errorf("handlerGetSessions: want the session of family %v, got %+v", session.FamilyID, got)
+	}
+	if calls := db.called("GetUserSessions"); len(calls) != 1 || calls[0][0] != userID.String() {
+		t.Errorf("handlerGetSessions: want the sessions of %v, got %v", userID, calls)
+	}
+}
+
+func TestRevokeSession(t *testing.T){
+	userID := uuid.New()
+	tests := []struct {
+		name		string
+		sessionID	string
+		//rows RevokeUserSession changes
+		revoked		int64
+		want		int
+	}{
+		{name: "own session", sessionID: uuid.NewString(), revoked: 1, want: 204},
+		{name: "someone else's or revoked", sessionID: uuid.NewString(), revoked: 0, want: 404},
+		{name: "bad id", sessionID: "abc", want: 400},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("RevokeUserSession", func(args []driver.Value) fakeResult {
+			return affected(tc.revoked)
+		})
+		cfg := db.apiConfig()
+
+		r := authedRequest("DELETE", "/api/sessions/"+tc.sessionID, nil, userID)
+		r.SetPathValue("sessionID", tc.sessionID)
+		w := httptest.NewRecorder()
+		cfg.handlerRevokeSession(w, r)
+		if w.Code != tc.want {
+			t.Errorf("handlerRevokeSession %s: want %d, got %d", tc.name, tc.want, w.Code)
+		}
+
+		//the session is only looked for among the caller's own
+		calls := db.called("RevokeUserSession")
+		if tc.want == 400 {
+			if len(calls) != 0 {
+				t.Errorf("handlerRevokeSession %s: want nothing revoked, got %v", tc.name, calls)
+			}
+			continue
+		}
+		if len(calls) != 1 || calls[0][0] != tc.sessionID || calls[0][1] != userID.String() {
+			t.Errorf("handlerRevokeSession %s: want session %s of %v revoked, got %v", tc.name, tc.sessionID, userID, calls)
+		}
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T){
+	userID := uuid.New()
+	db := newFakeDB(t)
+	db.on("RevokeAllUserRefreshTokens", func(args []driver.Value) fakeResult {
+		return affected(3)
+	})
+	cfg := db.apiConfig()
+
+	w := httptest.NewRecorder()
+	cfg.handlerRevokeAllSessions(w, authedRequest("DELETE", "/api/sessions", nil, userID))
+	if w.Code != 204 {
+		t.Errorf("handlerRevokeAllSessions: want 204, got %d", w.Code)
+	}
+	if calls := db.called("RevokeAllUserRefreshTokens"); len(calls) != 1 || calls[0][0] != userID.String() {
+		t.Errorf("handlerRevokeAllSessions: want the sessions of %v revoked, got %v", userID, calls)
+	}
+}
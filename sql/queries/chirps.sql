@@ -1,26 +1,147 @@
 -- name: CreateChirps :one
-INSERT INTO chirps (body, user_id)
+INSERT INTO chirps (body, user_id, parent_id, root_id)
 VALUES (
     $1,
-    $2
-) RETURNING *;
+    $2,
+    $3,
+    $4
+) RETURNING id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at;
 
--- name: GetChirps :many
-SELECT *
+-- name: ListChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
 FROM chirps
-ORDER BY created_at ASC;
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id)::uuid)
+    AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+        OR (chirps.created_at, chirps.id) > (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg(page_size);
+
+-- name: ListChirpsDesc :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id)::uuid)
+    AND (sqlc.narg(cursor_created_at)::timestamp IS NULL
+        OR (chirps.created_at, chirps.id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(page_size);
 
 -- name: GetChirp :one
-SELECT *
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at
 FROM chirps
-WHERE id = $1;
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1 AND users.deleted_at IS NULL AND chirps.deleted_at IS NULL;
+
+-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at
+FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET
+    body = $2,
+    updated_at = now(),
+    edit_count = edit_count + 1
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, edit_count, parent_id, root_id, reply_count, deleted_at;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE id = $1;
 
--- name: GetChirpsByAuthor :many
-SELECT *
+-- name: GetUserChirpIDs :many
+SELECT id
 FROM chirps
 WHERE user_id = $1
-ORDER BY created_at ASC;
+ORDER BY created_at, id;
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET
+    body = '',
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1;
+
+-- name: GetThreadChirp :one
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.parent_id, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = sqlc.arg(chirp_id)
+  UNION ALL
+    SELECT chirps.id, chirps.parent_id, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.parent_id
+)
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted
+FROM ancestors
+JOIN chirps ON chirps.id = ancestors.id
+JOIN users ON users.id = chirps.user_id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpReplies :many
+WITH RECURSIVE replies AS (
+    SELECT chirps.id, 1 AS depth,
+        ARRAY[to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text] AS path
+    FROM chirps
+    WHERE chirps.parent_id = sqlc.arg(chirp_id)
+  UNION ALL
+    SELECT chirps.id, replies.depth + 1,
+        replies.path || (to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text)
+    FROM chirps
+    JOIN replies ON chirps.parent_id = replies.id
+    WHERE replies.depth < sqlc.arg(max_depth)::integer
+)
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    (chirps.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL)::boolean AS deleted,
+    replies.depth::integer AS depth,
+    replies.path::text[] AS path
+FROM replies
+JOIN chirps ON chirps.id = replies.id
+JOIN users ON users.id = chirps.user_id
+WHERE replies.path > sqlc.arg(after_path)::text[]
+ORDER BY replies.path
+LIMIT sqlc.arg(page_size);
+
+-- name: SearchChirps :many
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edit_count,
+    chirps.parent_id, chirps.root_id, chirps.reply_count,
+    ts_rank_cd(chirps.search_vector, to_tsquery('english', sqlc.arg(query)))::real AS rank,
+    ts_headline('english', translate(chirps.body, chr(2) || chr(3), ''), to_tsquery('english', sqlc.arg(query)),
+        'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MinWords=10, MaxWords=30, MaxFragments=2')::text AS snippet
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL AND chirps.deleted_at IS NULL
+    AND chirps.search_vector @@ to_tsquery('english', sqlc.arg(query))
+    AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id)::uuid)
+ORDER BY
+    CASE WHEN sqlc.arg(by_relevance)::boolean
+        THEN ts_rank_cd(chirps.search_vector, to_tsquery('english', sqlc.arg(query)))
+    END DESC,
+    chirps.created_at DESC,
+    chirps.id DESC
+LIMIT sqlc.arg(page_size);
@@ -0,0 +1,24 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET
+    used_at = now()
+FROM users
+WHERE email_verification_tokens.token_hash = $1
+    AND email_verification_tokens.used_at IS NULL
+    AND email_verification_tokens.expires_at > now()
+    AND users.id = email_verification_tokens.user_id
+    AND users.email = email_verification_tokens.email
+RETURNING email_verification_tokens.user_id;
+
+-- name: DeleteUserEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1;
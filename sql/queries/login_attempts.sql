@@ -0,0 +1,31 @@
+-- name: GetLoginLockouts :many
+SELECT *
+FROM login_attempts
+WHERE key = ANY(sqlc.arg(keys)::text[])
+    AND locked_until > now();
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES (
+    $1,
+    1,
+    now()
+)
+ON CONFLICT (key) DO UPDATE
+SET
+    failures = CASE
+        WHEN login_attempts.last_failure_at < now() - interval '1 day' THEN 1
+        ELSE login_attempts.failures + 1
+    END,
+    last_failure_at = now()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_attempts
+SET
+    locked_until = $2
+WHERE key = $1;
+
+-- name: ClearLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE key = $1;
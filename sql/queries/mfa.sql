@@ -0,0 +1,75 @@
+-- name: UpsertUserTOTP :exec
+INSERT INTO user_totp (user_id, secret)
+VALUES (
+    $1,
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET
+    secret = EXCLUDED.secret,
+    created_at = now(),
+    confirmed_at = NULL,
+    last_used_step = 0;
+
+-- name: GetUserTOTP :one
+SELECT *
+FROM user_totp
+WHERE user_id = $1;
+
+-- name: ConfirmUserTOTP :exec
+UPDATE user_totp
+SET
+    confirmed_at = now(),
+    last_used_step = $2
+WHERE user_id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET
+    last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (code_hash, user_id)
+VALUES (
+    $1,
+    $2
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET
+    used_at = now()
+WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL;
+
+-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: ClaimMFAChallengeAttempt :one
+UPDATE mfa_challenges
+SET
+    attempts = attempts + 1
+WHERE token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+    AND attempts < sqlc.arg(max_attempts)::integer
+RETURNING *;
+
+-- name: UseMFAChallenge :execrows
+UPDATE mfa_challenges
+SET
+    used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL;
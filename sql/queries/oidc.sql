@@ -0,0 +1,33 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1
+    AND expires_at > now()
+RETURNING nonce, code_verifier;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at <= now();
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, issuer, subject, email)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT *
+FROM user_identities
+WHERE issuer = $1 AND subject = $2;
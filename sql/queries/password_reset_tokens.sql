@@ -0,0 +1,20 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET
+    used_at = now()
+WHERE token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING user_id;
+
+-- name: DeleteUserPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
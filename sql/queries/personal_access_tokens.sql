@@ -0,0 +1,40 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+) RETURNING *;
+
+-- name: GetActivePersonalAccessToken :one
+SELECT *
+FROM personal_access_tokens
+WHERE token_hash = $1
+    AND revoked_at IS NULL
+    AND (expires_at IS NULL OR expires_at > now());
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET
+    last_used_at = now()
+WHERE id = $1;
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT *
+FROM personal_access_tokens
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET
+    revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllUserPersonalAccessTokens :exec
+UPDATE personal_access_tokens
+SET
+    revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -1,20 +1,59 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, updated_at, expires_at)
+INSERT INTO refresh_tokens (token_hash, user_id, updated_at, expires_at, family_id, user_agent, ip_address)
 VALUES (
     $1,
     $2,
     $3,
-    $4
+    $4,
+    $5,
+    $6,
+    $7
 ) RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
 SELECT *
 FROM refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
 
--- name: RevokeRefreshToken :exec
+-- name: ConsumeRefreshToken :one
 UPDATE refresh_tokens
 SET
     updated_at = now(),
     revoked_at = now()
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1 AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: GetUserSessions :many
+SELECT
+    rt.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id)::timestamp AS started_at,
+    rt.created_at AS last_used_at,
+    rt.expires_at,
+    rt.user_agent,
+    rt.ip_address
+FROM refresh_tokens rt
+WHERE rt.user_id = $1
+    AND rt.revoked_at IS NULL
+    AND rt.expires_at > now()
+ORDER BY rt.created_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,18 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (jti, user_id, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: GetRevokedAccessTokensSince :many
+SELECT jti, expires_at, revoked_at
+FROM revoked_access_tokens
+WHERE revoked_at >= $1
+    AND expires_at > now();
+
+-- name: DeleteExpiredRevokedAccessTokens :execrows
+DELETE FROM revoked_access_tokens
+WHERE expires_at <= now();
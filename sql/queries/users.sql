@@ -18,11 +18,79 @@ WHERE email = $1;
 UPDATE users
 SET
     hashed_password = $1,
-    email = $2
+    email = $2,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
 WHERE id = $3;
 
 -- name: UpgradeUser :exec
 UPDATE users
 SET
     is_chirpy_red = true
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: GetUserTokenVersion :one
+SELECT token_version
+FROM users
+WHERE id = $1;
+
+-- name: IncrementTokenVersion :exec
+UPDATE users
+SET
+    token_version = token_version + 1
+WHERE id = $1;
+
+-- name: MarkEmailVerified :exec
+UPDATE users
+SET
+    email_verified_at = now()
+WHERE id = $1 AND email_verified_at IS NULL;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    hashed_password = $1,
+    updated_at = now()
+WHERE id = $2;
+
+-- name: SetUserRole :exec
+UPDATE users
+SET
+    role = $1,
+    token_version = token_version + 1,
+    updated_at = now()
+WHERE id = $2;
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING deleted_at;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET
+    deleted_at = NULL,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: GetUsersToPurge :many
+SELECT id
+FROM users
+WHERE deleted_at < @deleted_before::timestamp;
+
+-- name: LockUserToPurge :one
+SELECT id
+FROM users
+WHERE id = $1 AND deleted_at < @deleted_before::timestamp
+FOR UPDATE;
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+-- re-key existing rows so sessions issued before this migration keep working
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- the raw tokens cannot be recovered from their digests, so drop them all
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chirps
+ADD COLUMN parent_id UUID DEFAULT NULL REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN root_id UUID DEFAULT NULL REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN deleted_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX chirps_parent_id_created_at_id_idx ON chirps (parent_id, created_at, id);
+CREATE INDEX chirps_root_id_idx ON chirps (root_id);
+
+-- keep reply_count right however replies go away, including when their
+-- author's account is purged
+CREATE FUNCTION chirps_update_reply_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' AND NEW.parent_id IS NOT NULL THEN
+        UPDATE chirps SET reply_count = reply_count + 1 WHERE id = NEW.parent_id;
+    ELSIF TG_OP = 'DELETE' AND OLD.parent_id IS NOT NULL THEN
+        UPDATE chirps SET reply_count = reply_count - 1 WHERE id = OLD.parent_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER chirps_reply_count
+AFTER INSERT OR DELETE ON chirps
+FOR EACH ROW EXECUTE FUNCTION chirps_update_reply_count();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER chirps_reply_count ON chirps;
+DROP FUNCTION chirps_update_reply_count();
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN reply_count,
+DROP COLUMN root_id,
+DROP COLUMN parent_id;
+-- +goose StatementEnd
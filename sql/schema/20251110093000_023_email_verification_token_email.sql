@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tokens sent before this can't be tied to an address, users can ask for a
+-- new one
+DELETE FROM email_verification_tokens;
+
+ALTER TABLE email_verification_tokens
+ADD COLUMN email TEXT NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE email_verification_tokens
+DROP COLUMN email;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- purging an account deletes its chirps one by one like their author would,
+-- the deleted chirps kept for their replies outlive the account
+ALTER TABLE chirps
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- chirps of purged accounts have nobody to belong to anymore, deciding what
+-- happens to them is up to whoever migrates down
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM chirps WHERE user_id IS NULL) THEN
+        RAISE EXCEPTION 'chirps of purged accounts have no user_id, delete or reassign them before migrating down';
+    END IF;
+END
+$$;
+
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+ALTER COLUMN user_id SET NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+-- replies stay in their threads when the chirp they reply to is deleted with
+-- a purged account, so parent_id and root_id can point at chirps that are gone
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_parent_id_fkey,
+DROP CONSTRAINT chirps_root_id_fkey;
+
+-- purging an account deletes its chirps again through ON DELETE CASCADE, the
+-- ones kept for their replies so far go the same way
+DELETE FROM chirps
+WHERE user_id IS NULL;
+
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+ALTER COLUMN user_id SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- the foreign keys can't come back while replies point at deleted chirps
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1
+        FROM chirps
+        WHERE (parent_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM chirps parent WHERE parent.id = chirps.parent_id))
+            OR (root_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM chirps root WHERE root.id = chirps.root_id))
+    ) THEN
+        RAISE EXCEPTION 'replies to chirps of purged accounts point at deleted chirps, clear their parent_id and root_id before migrating down';
+    END IF;
+END
+$$;
+
+ALTER TABLE chirps
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+ADD CONSTRAINT chirps_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES chirps(id) ON DELETE SET NULL,
+ADD CONSTRAINT chirps_root_id_fkey FOREIGN KEY (root_id) REFERENCES chirps(id) ON DELETE SET NULL;
+-- +goose StatementEnd
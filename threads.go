@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//replies nested deeper than this below the requested chirp are left out of
+//its thread, the deepest chirps shown have a reply_count to follow instead
+const maxThreadDepth = 50
+
+//a chirp in a thread. Deleted chirps that still have replies stay in their
+//threads with the body and author blanked.
+type ThreadChirp struct {
+	Chirp
+	Deleted	bool `json:"deleted"`
+	//how far below the requested chirp a reply is, 1 for direct replies
+	Depth	int32 `json:"depth,omitempty"`
+}
+
+//a chirp with the chirps it replies to, root first, and a page of the replies
+//below it, each reply right after its parent and siblings oldest first
+type ChirpThread struct {
+	Chirp		ThreadChirp `json:"chirp"`
+	Ancestors	[]ThreadChirp `json:"ancestors"`
+	Replies		[]ThreadChirp `json:"replies"`
+	NextCursor	*string `json:"next_cursor"`
+}
+
+func nullUUIDPtr(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+func threadChirp(c database.GetChirpAncestorsRow, depth int32) ThreadChirp {
+	tc := ThreadChirp{
+		Chirp: Chirp{
+			ID: c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body: c.Body,
+			UserID: c.UserID,
+			Edited: c.EditCount > 0,
+			EditCount: c.EditCount,
+			InReplyTo: nullUUIDPtr(c.ParentID),
+			RootID: nullUUIDPtr(c.RootID),
+			ReplyCount: c.ReplyCount,
+		},
+		Deleted: c.Deleted,
+		Depth: depth,
+	}
+	//chirps of accounts waiting to be purged are hidden like deleted ones
+	if c.Deleted {
+		tc.Body = ""
+		tc.UserID = uuid.Nil
+	}
+	return tc
+}
+
+//get a chirp with its ancestors and a page of its replies, ?limit= and
+//?cursor= page through the replies
+func (cfg *apiConfig) handlerGetChirpThread(w http.ResponseWriter, r *http.Request){
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Error parsing chirp ID from string to UUID: %v", err)
+		respondWithError(w, 400, "Error parsing chirp ID")
+		return
+	}
+
+	query := r.URL.Query()
+	pageSize, err := parsePageSize(query.Get("limit"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	afterPath, err := decodeThreadCursor(query.Get("cursor"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid cursor")
+		return
+	}
+
+	//deleted chirps kept for their replies still lead to their conversation
+	chirp, err := cfg.dbQueries.GetThreadChirp(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows){
+		respondWithError(w, 404, "Matching chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting chirp by id: %v", err)
+		respondWithError(w, 500, "Error getting thread")
+		return
+	}
+
+	ancestors, err := cfg.dbQueries.GetChirpAncestors(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting chirp ancestors: %v", err)
+		respondWithError(w, 500, "Error getting thread")
+		return
+	}
+
+	replies, err := cfg.dbQueries.GetChirpReplies(r.Context(), database.GetChirpRepliesParams{
+		ChirpID: id,
+		MaxDepth: maxThreadDepth,
+		AfterPath: afterPath,
+		//fetch one extra reply to find out if there is a next page
+		PageSize: pageSize + 1,
+	})
+	if err != nil {
+		log.Printf("Error getting chirp replies: %v", err)
+		respondWithError(w, 500, "Error getting thread")
+		return
+	}
+
+	resp := ChirpThread{
+		Chirp: threadChirp(database.GetChirpAncestorsRow(chirp), 0),
+		Ancestors: make([]ThreadChirp, len(ancestors)),
+		Replies: make([]ThreadChirp, 0, len(replies)),
+	}
+	for i, c := range ancestors {
+		resp.Ancestors[i] = threadChirp(c, 0)
+	}
+	if len(replies) > int(pageSize) {
+		replies = replies[:pageSize]
+		nextCursor := encodeThreadCursor(replies[len(replies)-1].Path)
+		resp.NextCursor = &nextCursor
+		cfg.setNextPageLink(w, r, nextCursor)
+	}
+	for _, c := range replies {
+		resp.Replies = append(resp.Replies, threadChirp(database.GetChirpAncestorsRow{
+			ID: c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body: c.Body,
+			UserID: c.UserID,
+			EditCount: c.EditCount,
+			ParentID: c.ParentID,
+			RootID: c.RootID,
+			ReplyCount: c.ReplyCount,
+			Deleted: c.Deleted,
+		}, c.Depth))
+	}
+
+	respondWithJSON(w, 200, resp)
+}
+
+//the queries deleting a chirp takes, *database.Queries does them
+type chirpDeleter interface {
+	GetChirpForUpdate(ctx context.Context, id uuid.UUID) (database.GetChirpForUpdateRow, error)
+	TombstoneChirp(ctx context.Context, id uuid.UUID) error
+	DeleteChirpRevisions(ctx context.Context, chirpID uuid.UUID) error
+	DeleteChirp(ctx context.Context, id uuid.UUID) error
+}
+
+//delete a chirp. A chirp with replies is blanked instead so its thread holds
+//together, and blanked chirps go once their last reply is deleted.
+func (cfg *apiConfig) deleteChirp(ctx context.Context, id uuid.UUID) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteChirpTx(ctx, cfg.dbQueries.WithTx(tx), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//the work of deleteChirp, inside the caller's transaction
+func deleteChirpTx(ctx context.Context, q chirpDeleter, id uuid.UUID) error {
+	//lock the chirp so a reply can't sneak in between counting and deleting
+	chirp, err := q.GetChirpForUpdate(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if chirp.ReplyCount > 0 {
+		if err := q.TombstoneChirp(ctx, id); err != nil {
+			return err
+		}
+		//the earlier bodies go with the current one
+		return q.DeleteChirpRevisions(ctx, id)
+	}
+	return removeChirpTx(ctx, q, chirp)
+}
+
+//delete a chirp for good, replies or not, along with the blanked chirps above
+//it that have no replies left. Replies to it keep it as their parent and root,
+//they just can't be looked up anymore.
+func removeChirpTx(ctx context.Context, q chirpDeleter, chirp database.GetChirpForUpdateRow) error {
+	//the reply_count trigger updates the parent as each chirp goes
+	if err := q.DeleteChirp(ctx, chirp.ID); err != nil {
+		return err
+	}
+	for parentID := chirp.ParentID; parentID.Valid; {
+		parent, err := q.GetChirpForUpdate(ctx, parentID.UUID)
+		//replies to a chirp of a purged account have no parent left
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !parent.DeletedAt.Valid || parent.ReplyCount > 0 {
+			return nil
+		}
+		if err := q.DeleteChirp(ctx, parent.ID); err != nil {
+			return err
+		}
+		parentID = parent.ParentID
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+func TestGetChirpThread(t *testing.T){
+	posted := time.Date(2025, 11, 6, 10, 0, 0, 0, time.UTC)
+	chirpRow := func(parent *database.GetChirpAncestorsRow) database.GetChirpAncestorsRow {
+		posted = posted.Add(time.Minute)
+		c := database.GetChirpAncestorsRow{
+			ID: uuid.New(),
+			CreatedAt: posted,
+			UpdatedAt: posted,
+			Body: "chirp",
+			UserID: uuid.New(),
+		}
+		if parent != nil {
+			c.ParentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+			c.RootID = parent.RootID
+			if !c.RootID.Valid {
+				c.RootID = c.ParentID
+			}
+		}
+		return c
+	}
+	replyRow := func(c database.GetChirpAncestorsRow, depth int32, path ...string) database.GetChirpRepliesRow {
+		return database.GetChirpRepliesRow{
+			ID: c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body: c.Body,
+			UserID: c.UserID,
+			ParentID: c.ParentID,
+			RootID: c.RootID,
+			Depth: depth,
+			Path: path,
+		}
+	}
+
+	//the requested chirp was deleted but still has two replies, one of them
+	//with a reply of its own
+	root := chirpRow(nil)
+	deleted := chirpRow(&root)
+	deleted.Body = ""
+	deleted.Deleted = true
+	deleted.ReplyCount = 2
+	first := chirpRow(&deleted)
+	nested := chirpRow(&first)
+	second := chirpRow(&deleted)
+	replies := []database.GetChirpRepliesRow{
+		replyRow(first, 1, "a"),
+		replyRow(nested, 2, "a", "b"),
+		replyRow(second, 1, "c"),
+	}
+
+	db := newFakeDB(t)
+	db.on("GetThreadChirp", func(args []driver.Value) fakeResult {
+		if args[0] != deleted.ID.String() {
+			return rowsOf()
+		}
+		return rowsOf(database.GetThreadChirpRow(deleted))
+	})
+	db.on("GetChirpAncestors", func(args []driver.Value) fakeResult {
+		return rowsOf(root)
+	})
+	db.on("GetChirpReplies", func(args []driver.Value) fakeResult {
+		values := []any{}
+		for i := 0; i < len(replies) && i < int(args[3].(int64)); i++ {
+			values = append(values, replies[i])
+		}
+		return rowsOf(values...)
+	})
+	cfg := db.apiConfig()
+
+	r := httptest.NewRequest("GET", "/api/chirps/"+deleted.ID.String()+"/thread?limit=2", nil)
+	r.SetPathValue("chirpID", deleted.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerGetChirpThread(w, r)
+	if w.Code != 200 {
+		t.Fatalf("handlerGetChirpThread of a deleted chirp with replies: want 200, got %d %s", w.Code, w.Body)
+	}
+	var resp ChirpThread
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding thread err: %v", err)
+	}
+
+	//the deleted chirp shows up without its body or author
+	if resp.Chirp.ID != deleted.ID || !resp.Chirp.Deleted || resp.Chirp.Body != "" || resp.Chirp.UserID != uuid.Nil || resp.Chirp.ReplyCount != 2 {
+		t.Errorf("handlerGetChirpThread: want the deleted chirp blanked, got %+v", resp.Chirp)
+	}
+	if len(resp.Ancestors) != 1 || resp.Ancestors[0].ID != root.ID {
+		t.Errorf("handlerGetChirpThread: want ancestors [%v], got %+v", root.ID, resp.Ancestors)
+	}
+	if len(resp.Replies) != 2 || resp.Replies[0].ID != first.ID || resp.Replies[1].ID != nested.ID || resp.Replies[1].Depth != 2 {
+		t.Errorf("handlerGetChirpThread: want the first 2 replies depth first, got %+v", resp.Replies)
+	}
+	if resp.Replies[0].InReplyTo == nil || *resp.Replies[0].InReplyTo != deleted.ID {
+		t.Errorf("handlerGetChirpThread: want the replies to still reply to the deleted chirp, got %+v", resp.Replies[0])
+	}
+	if resp.NextCursor == nil {
+		t.Fatalf("handlerGetChirpThread: want a next cursor")
+	}
+	path, err := decodeThreadCursor(*resp.NextCursor)
+	if err != nil || len(path) != 2 || path[1] != "b" {
+		t.Errorf("handlerGetChirpThread: want a cursor after the nested reply, got %v, %v", path, err)
+	}
+}
+
+func TestGetChirpThreadErrors(t *testing.T){
+	tests := []struct {
+		name	string
+		chirpID	string
+		query	string
+		want	int
+	}{
+		{name: "unknown chirp", chirpID: uuid.NewString(), want: 404},
+		{name: "bad id", chirpID: "abc", want: 400},
+		{name: "garbage cursor", chirpID: uuid.NewString(), query: "cursor=garbage!", want: 400},
+		{name: "chirp list cursor", chirpID: uuid.NewString(), query: "cursor=" + encodeChirpCursor(time.Now(), uuid.New()), want: 400},
+		{name: "bad limit", chirpID: uuid.NewString(), query: "limit=0", want: 400},
+	}
+
+	for _, tc := range tests {
+		db := newFakeDB(t)
+		db.on("GetThreadChirp", func(args []driver.Value) fakeResult {
+			return rowsOf()
+		})
+		cfg := db.apiConfig()
+
+		r := httptest.NewRequest("GET", "/api/chirps/"+tc.chirpID+"/thread?"+tc.query, nil)
+		r.SetPathValue("chirpID", tc.chirpID)
+		w := httptest.NewRecorder()
+		cfg.handlerGetChirpThread(w, r)
+		if w.Code != tc.want {
+			t.Errorf("handlerGetChirpThread %s: want %d, got %d %s", tc.name, tc.want, w.Code, w.Body)
+		}
+		//bad requests don't get as far as the database
+		if tc.want == 400 && len(db.calls) != 0 {
+			t.Errorf("handlerGetChirpThread %s: want no queries, got %v", tc.name, db.calls)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+)
+
+//longest name a personal access token can have
+const maxTokenNameLength = 100
+
+//a personal access token as listed to its owner, without the secret
+type PersonalAccessToken struct {
+	ID			uuid.UUID `json:"id"`
+	Name		string `json:"name"`
+	Scopes		[]string `json:"scopes"`
+	CreatedAt	time.Time `json:"created_at"`
+	LastUsedAt	*time.Time `json:"last_used_at"`
+	ExpiresAt	*time.Time `json:"expires_at"`
+}
+
+//a new personal access token, the only time the secret is shown
+type CreatedPersonalAccessToken struct {
+	PersonalAccessToken
+	Token	string `json:"token"`
+}
+
+func personalAccessTokenResponse(t database.PersonalAccessToken) PersonalAccessToken {
+	resp := PersonalAccessToken{
+		ID: t.ID,
+		Name: t.Name,
+		Scopes: t.Scopes,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.LastUsedAt.Valid {
+		resp.LastUsedAt = &t.LastUsedAt.Time
+	}
+	if t.ExpiresAt.Valid {
+		resp.ExpiresAt = &t.ExpiresAt.Time
+	}
+	return resp
+}
+
+//look up a personal access token for the authenticator and note its use
+func (cfg *apiConfig) lookupPersonalAccessToken(ctx context.Context, tokenHash string) (uuid.UUID, []string, error) {
+	token, err := cfg.dbQueries.GetActivePersonalAccessToken(ctx, tokenHash)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if err := cfg.dbQueries.TouchPersonalAccessToken(ctx, token.ID); err != nil {
+		log.Printf("Error updating personal access token last use: %v", err)
+	}
+	return token.UserID, token.Scopes, nil
+}
+
+//create a personal access token for the logged in user
+func (cfg *apiConfig) handlerCreateToken(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	type parameters struct {
+		Name		string `json:"name"`
+		Scopes		[]string `json:"scopes"`
+		//optional, tokens without one never expire
+		ExpiresAt	*time.Time `json:"expires_at"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, 400, "Token name must be 1 to 100 characters")
+		return
+	}
+	if err := auth.ValidateScopes(params.Scopes); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(w, 400, "Token expiry must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		log.Printf("Error creating personal access token: %v", err)
+		respondWithError(w, 500, "Error creating token")
+		return
+	}
+
+	created, err := cfg.dbQueries.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID: userID,
+		Name: params.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes: params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error storing personal access token: %v", err)
+		respondWithError(w, 500, "Error creating token")
+		return
+	}
+
+	respondWithJSON(w, 201, CreatedPersonalAccessToken{
+		PersonalAccessToken: personalAccessTokenResponse(created),
+		Token: token,
+	})
+}
+
+//list the personal access tokens of the logged in user
+func (cfg *apiConfig) handlerGetTokens(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	tokens, err := cfg.dbQueries.GetUserPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting personal access tokens: %v", err)
+		respondWithError(w, 500, "Error getting tokens")
+		return
+	}
+
+	resp := make([]PersonalAccessToken, len(tokens))
+	for i, t := range tokens {
+		resp[i] = personalAccessTokenResponse(t)
+	}
+
+	respondWithJSON(w, 200, resp)
+}
+
+//revoke one of the logged in user's personal access tokens
+func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		log.Printf("Error parsing token ID from string to UUID: %v", err)
+		respondWithError(w, 400, "Error parsing token ID")
+		return
+	}
+
+	//someone else's token looks the same as one that doesn't exist
+	revoked, err := cfg.dbQueries.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID: tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error revoking personal access token: %v", err)
+		respondWithError(w, 500, "Error revoking token")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "token not found")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
@@ -1,12 +1,33 @@
 package main
 
 import (
+	"errors"
 	"slices"
 	"strings"
+	"net"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
 )
 
 var profanityTexts = []string{"kerfuffle", "sharbert", "fornax"}
 
+//longest chirp in characters
+const maxChirpLength = 280
+
+var errInvalidChirp = errors.New("chirps must be 1 to 280 characters")
+
+//the rules every chirp body goes through, when posted and when edited.
+//Returns the body as it should be stored.
+func validateChirpBody(body string) (string, error) {
+	body = stripControlChars(body)
+	runeCount := utf8.RuneCountInString(body)
+	if runeCount == 0 || runeCount > maxChirpLength {
+		return "", errInvalidChirp
+	}
+	return cleanProfanity(body), nil
+}
+
 func cleanProfanity(text string) string{
 	textFields := strings.Fields(text)
 	for i,text := range textFields{
@@ -18,3 +39,23 @@ func cleanProfanity(text string) string{
 	return joinText
 }
 
+//remove control characters from chirp bodies. Nobody needs them in a chirp,
+//and search snippets use \x02 and \x03 to mark matches.
+func stripControlChars(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+
+//get the IP address of the client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripControlChars(t *testing.T){
+	tests := []struct {
+		input	string
+		want	string
+	}{
+		{input: "hello world", want: "hello world"},
+		{input: "a \x02fake\x03 mark", want: "a fake mark"},
+		{input: "bell\x07 and del\x7f", want: "bell and del"},
+		{input: "line\nbreak\ttab", want: "line\nbreak\ttab"},
+	}
+
+	for _, tc := range tests {
+		if got := stripControlChars(tc.input); got != tc.want {
+			t.Errorf("stripControlChars(%q): want %q, got %q", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestValidateChirpBody(t *testing.T){
+	tests := []struct {
+		input	string
+		want	string
+		wantErr	bool
+	}{
+		{input: "hello world", want: "hello world"},
+		{input: "what a Kerfuffle!", want: "what a Kerfuffle!"},
+		{input: "what a kerfuffle", want: "what a ****"},
+		{input: "\x02marked\x03", want: "marked"},
+		{input: strings.Repeat("é", maxChirpLength), want: strings.Repeat("é", maxChirpLength)},
+		{input: "", wantErr: true},
+		{input: "\x02\x03", wantErr: true},
+		{input: strings.Repeat("a", maxChirpLength+1), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := validateChirpBody(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("validateChirpBody(%q) err: %v, want error %v", tc.input, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("validateChirpBody(%q): want %q, got %q", tc.input, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/paul39-33/chirpy/internal/auth"
+	"github.com/paul39-33/chirpy/internal/database"
+	"github.com/paul39-33/chirpy/internal/mailer"
+)
+
+//email verification links are valid for a day
+const emailVerificationExp = 24 * time.Hour
+
+//create a single use verification token for the user and email it to them
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.dbQueries.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID: user.ID,
+		//the token only verifies the address it was sent to
+		Email: user.Email,
+		ExpiresAt: time.Now().Add(emailVerificationExp),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To: user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Confirm your email address by sending this token to POST %s/api/users/verify:\n\n%s\n\n"+
+			"The token expires in %v.", cfg.baseURL, token, emailVerificationExp),
+	})
+}
+
+//tell the owner of an account that someone tried to sign up with their email,
+//which signup itself doesn't reveal
+func (cfg *apiConfig) sendAlreadyRegisteredEmail(ctx context.Context, user database.User) error {
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To: user.Email,
+		Subject: "You already have a Chirpy account",
+		Body: fmt.Sprintf("Someone tried to sign up for Chirpy with this email address, "+
+			"but it already belongs to an account.\n\n"+
+			"If that was you, log in at POST %s/api/login, or reset your password at POST %s/api/password/forgot. "+
+			"If it wasn't, you can ignore this email.", cfg.baseURL, cfg.baseURL),
+	})
+}
+
+//mark the email of the user owning the token as verified
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request){
+	type parameters struct {
+		Token	string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		respondWithError(w, 400, "Error decoding request")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		respondWithError(w, 500, "Error verifying email")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userID, err := qtx.UseEmailVerificationToken(r.Context(), auth.HashToken(params.Token))
+	//unknown, used and expired tokens and tokens sent to an earlier address
+	//all look the same
+	if errors.Is(err, sql.ErrNoRows){
+		respondWithError(w, 400, "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		log.Printf("Error using verification token: %v", err)
+		respondWithError(w, 500, "Error verifying email")
+		return
+	}
+
+	if err := qtx.MarkEmailVerified(r.Context(), userID); err != nil {
+		log.Printf("Error marking email verified: %v", err)
+		respondWithError(w, 500, "Error verifying email")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing email verification: %v", err)
+		respondWithError(w, 500, "Error verifying email")
+		return
+	}
+
+	respondWithJSON(w, 204, "")
+}
+
+//send a new verification email to the logged in user
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request){
+	principal := auth.MustPrincipal(r)
+	userID := principal.UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user data: %v", err)
+		respondWithError(w, 500, "Error retrieving user data")
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "Email already verified")
+		return
+	}
+
+	//only the newest link should work
+	if err := cfg.dbQueries.DeleteUserEmailVerificationTokens(r.Context(), user.ID); err != nil {
+		log.Printf("Error deleting old verification tokens: %v", err)
+		respondWithError(w, 500, "Error sending verification email")
+		return
+	}
+	if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		respondWithError(w, 500, "Error sending verification email")
+		return
+	}
+
+	respondWithJSON(w, 202, "")
+}